@@ -10,4 +10,5 @@
 //   - Ignore: Applies gitignore-style patterns
 //   - Seen: Prevents duplicate file inclusion
 //   - Size: Enforces file size limits
+//   - Tokens: Enforces a budget on the estimated number of tokens
 package checkers
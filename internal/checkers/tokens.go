@@ -0,0 +1,58 @@
+package checkers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/idelchi/aggr/internal/tokens"
+	"github.com/idelchi/godyl/pkg/path/file"
+)
+
+// Tokens is a checker that enforces a budget on the estimated number of tokens of all accepted files.
+// Files are accepted in the order they are checked, as long as they fit into the remaining budget,
+// so it should be applied after all other checkers.
+type Tokens struct {
+	// Budget is the maximum total number of tokens.
+	Budget int
+	// Tokenizer estimates the number of tokens in a file.
+	Tokenizer tokens.Tokenizer
+	// Total is the number of tokens of the files accepted so far.
+	Total int
+}
+
+// NewTokens creates a new Tokens checker with the specified budget and tokenizer.
+func NewTokens(budget int, tokenizer tokens.Tokenizer) *Tokens {
+	return &Tokens{Budget: budget, Tokenizer: tokenizer}
+}
+
+// Check returns an error if the file does not fit into the remaining token budget.
+// Only regular files are counted; directories and symbolic links have no content.
+func (t *Tokens) Check(base, path string) error {
+	file := file.New(base, path)
+
+	info, err := os.Lstat(file.Path())
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	data, err := file.Read()
+	if err != nil {
+		return nil //nolint:nilerr	// Unreadable files are reported when packing.
+	}
+
+	// Binary files are packed as base64, which is what counts towards the budget.
+	if NewBinary().Check(base, path) != nil {
+		data = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+
+	count := t.Tokenizer.Count(data)
+
+	if t.Total+count > t.Budget {
+		return fmt.Errorf("%w: ~%d tokens exceed the remaining token budget of %d", ErrSkip, count, t.Budget-t.Total)
+	}
+
+	t.Total += count
+
+	return nil
+}
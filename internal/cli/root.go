@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"runtime"
@@ -34,6 +35,10 @@ func Execute(version string) error {
 			In '--unpack' mode, reads an aggregated file and recreates the original files and directories.
 			The command extracts all files from the archive and restores them to their
 			original relative paths within the specified output directory.
+
+			In '--verify' mode, re-parses an aggregated file and checks every entry against its
+			recorded checksum and the archive against the digest in its footer.
+			When '--root' is passed, entries are also compared with the files in that directory.
 		`),
 		Example: heredoc.Doc(`
 			# Pack all files in the current directory and all subdirectories
@@ -42,20 +47,46 @@ func Execute(version string) error {
 			# Unpack the contents of the archive
 			aggr -u -o __extracted__ pack.aggr
 
+			# Unpack an archive piped from another command
+			curl -sSL https://example.com/pack.aggr | aggr -u -o __extracted__ -
+
 			# Pack all .txt and .md files in the folder 'docs'
 			aggr -x txt,md docs
+
+			# Pack into a gzip-compressed archive and unpack it again
+			aggr -o pack.aggr.gz
+			aggr -u pack.aggr.gz
+
+			# Pack into archives of at most 1mb each and unpack all parts together
+			aggr --split 1mb -o pack.aggr
+			aggr -u -o __extracted__ pack.*.aggr
+
+			# Pack using '#'-style markers
+			aggr --marker hash -o pack.aggr
+
+			# Pack into Markdown, to paste into a chat, and unpack it again
+			aggr --format markdown -o pack.md
+			aggr -u pack.md
+
+			# Verify the archive and compare it with the folder 'src'
+			aggr --verify -C src pack.aggr
+
+			# Show what unpacking an edited archive would change in the current folder
+			aggr --diff pack.aggr
+
+			# Unpack an edited archive over the current folder, keeping local changes made since packing pack.aggr
+			aggr -u -o . --base pack.aggr edited.aggr
+
+			# Make the folder 'src' mirror the archive, deleting the files removed from it
+			aggr -u -o src --update --prune pack.aggr
 		`),
 		Version:       version,
 		SilenceErrors: true,
 		SilenceUsage:  true,
 		Args: func(cmd *cobra.Command, args []string) error {
-			if configuration.Unpack {
-				if err := cobra.ExactArgs(1)(cmd, args); err != nil {
-					return fmt.Errorf(
-						"when unpacking, exactly one file argument is required, received %d arguments: %v",
-						len(args),
-						args,
-					)
+			if configuration.Unpack || configuration.Verify || configuration.Diff {
+				if err := cobra.MinimumNArgs(1)(cmd, args); err != nil {
+					return errors.New("when unpacking, verifying or comparing, at least one file argument is required")
 				}
 			}
 
@@ -63,13 +94,28 @@ func Execute(version string) error {
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			configuration.Rules.IgnoreFile.Set = cmd.Flags().Lookup("ignore-file").Changed
+			configuration.Compare = cmd.Flags().Lookup("root").Changed
+
+			// Unpacking, verifying and comparing detect the format unless it is passed explicitly.
+			reading := configuration.Unpack || configuration.Verify || configuration.Diff
+			if reading && !cmd.Flags().Lookup("format").Changed {
+				configuration.Format = ""
+			}
 
 			packer := packer.Packer{
 				Options: configuration,
 			}
 
 			if configuration.Unpack {
-				return packer.Unpack(args)
+				return packer.Unpack(cmd.Context(), args)
+			}
+
+			if configuration.Verify {
+				return packer.Verify(args)
+			}
+
+			if configuration.Diff {
+				return packer.Diff(cmd.Context(), args)
 			}
 
 			// Default to current directory if no args provided
@@ -89,12 +135,15 @@ func Execute(version string) error {
 	cobra.EnableCommandSorting = false
 
 	// Core operation
-	root.Flags().BoolVarP(&configuration.Unpack, "unpack", "u", false, "Unpack from a packed file")
+	root.Flags().BoolVarP(&configuration.Unpack, "unpack", "u", false, "Unpack from one or more packed files")
+	root.Flags().BoolVar(&configuration.Verify, "verify", false, "Verify the integrity of one or more packed files")
+	root.Flags().BoolVar(&configuration.Diff, "diff", false,
+		"Show how one or more packed files differ from the files under --root, as unified diffs")
 	root.Flags().
 		StringVarP(&configuration.Output, "output", "o", "",
 			fmt.Sprintf("Specify output file/folder. For packing, defaults to %q, for unpacking to %q",
 				"<folder>.aggr",
-				"<file>-[hash of <file>]"),
+				"<file>-[hash of <file>], or stdin-<timestamp> when reading from stdin"),
 		)
 
 	// What to include/exclude
@@ -107,21 +156,58 @@ func Execute(version string) error {
 		StringSliceVarP(&configuration.Rules.Patterns, "ignore", "i", []string{}, "Additional .aggrignore patterns")
 	root.Flags().BoolVarP(&configuration.Rules.Hidden, "hidden", "a", false, "Include hidden files and directories")
 	root.Flags().BoolVarP(&configuration.Rules.Binary, "binary", "b", false, "Include binary files")
+	root.Flags().BoolVar(&configuration.NoMetadata, "no-metadata", false,
+		"Do not restore file modes and modification times when unpacking")
+
+	// Existing files when unpacking. Without any of these, each one is prompted for on a terminal.
+	root.Flags().BoolVar(&configuration.Force, "force", false, "Overwrite existing files when unpacking")
+	root.Flags().BoolVar(&configuration.SkipExisting, "skip-existing", false, "Keep existing files when unpacking")
+	root.Flags().BoolVar(&configuration.Backup, "backup", false,
+		"Rename existing files by adding '.bak' before overwriting them when unpacking")
+	root.Flags().BoolVar(&configuration.FailIfExists, "fail-if-exists", false,
+		"Abort unpacking if a file already exists")
+	root.Flags().BoolVar(&configuration.Update, "update", false,
+		"Only write files that differ from the existing ones when unpacking, "+
+			"overwriting them unless a policy is passed")
+	root.Flags().StringVar(&configuration.Base, "base", "",
+		"Archive the existing files were unpacked from. Local changes since then are merged with the unpacked files")
+	root.Flags().StringVar(&configuration.ConflictStyle, "conflict-style", config.DefaultConflictStyle,
+		"How to write changes that conflict with local changes when merging: "+
+			"markers, or rej to write them to '.rej' files")
+	root.Flags().BoolVar(&configuration.Prune, "prune", false,
+		"Delete files that the archive covers but has no entry for when unpacking, "+
+			"making the output mirror the archive")
 
 	// Limits
 	root.Flags().StringVarP(&configuration.Rules.Size, "size", "s", config.DefaultMaxSize,
 		"Max file size to include (e.g., `500kb`, `1mb`)")
 	root.Flags().
 		IntVarP(&configuration.Rules.Max, "max", "m", config.DefaultMaxFiles, "Maximum number of files to include")
+	root.Flags().IntVar(&configuration.Rules.MaxTokens, "max-tokens", 0,
+		"Budget of estimated tokens for all included files. Files exceeding the remaining budget are skipped")
+
+	// Format
+	root.Flags().StringVar(&configuration.Format, "format", config.DefaultFormat,
+		"Archive format: aggr, markdown, xml, json or jsonl. Detected when unpacking or verifying unless passed")
+	root.Flags().StringVar(&configuration.Marker, "marker", config.DefaultMarker,
+		"Marker delimiting files: a preset (default, hash, dash, angle) or a custom string")
+
+	root.Flags().BoolVarP(&configuration.Compress, "compress", "z", false,
+		"Gzip-compress the output. Implied by an output name ending in '.gz'")
+	root.Flags().StringVar(&configuration.Split, "split", "",
+		"Split the output into numbered archives of at most this size (e.g., `1mb`), without splitting files")
 
 	// Behavior
-	root.Flags().
-		BoolVarP(&configuration.Dry, "dry", "d", false, "Show which files would be processed without reading contents")
+	root.Flags().BoolVarP(&configuration.Dry, "dry", "d", false,
+		"Show which files would be processed, with their lines and estimated tokens, without packing them")
 
 	defaultWorkers := 4 * runtime.NumCPU() //nolint:mnd	// 4xCPUs
 	root.Flags().
 		IntVarP(&configuration.Parallel, "parallel", "j", defaultWorkers, "Number of parallel workers to use")
 
+	root.MarkFlagsMutuallyExclusive("unpack", "verify", "diff")
+	root.MarkFlagsMutuallyExclusive("force", "skip-existing", "backup", "fail-if-exists")
+
 	options := []fang.Option{
 		fang.WithVersion(version),
 		fang.WithoutManpage(),
@@ -4,14 +4,47 @@ package config
 type Options struct {
 	// Output specifies the output file path for aggregated data.
 	Output string
+	// Compress indicates whether to gzip-compress the output.
+	Compress bool
+	// Split is the maximum size of each part when splitting the output into several archives.
+	// An empty value writes a single archive.
+	Split string
 	// Dry indicates whether to perform a dry run without writing output.
 	Dry bool
 	// Parallel defines the number of parallel workers to use during processing.
 	Parallel int
+	// Marker is the marker preset name or custom marker used to delimit files.
+	Marker string
+	// Format is the archive format name. When unpacking or verifying, an empty format is detected.
+	Format string
 	// Rules contains the file filtering and processing rules.
 	Rules Rules
 	// Unpack specifies whether to unpack.
 	Unpack bool
+	// Verify specifies whether to verify the integrity of a packed file.
+	Verify bool
+	// Diff specifies whether to compare a packed file with the files under Rules.Root.
+	Diff bool
+	// Compare indicates whether verification also compares entries with the files under Rules.Root.
+	Compare bool
+	// NoMetadata disables restoring recorded file modes and modification times when unpacking.
+	NoMetadata bool
+	// Force overwrites existing files when unpacking.
+	Force bool
+	// SkipExisting keeps existing files when unpacking.
+	SkipExisting bool
+	// Backup renames existing files before overwriting them when unpacking.
+	Backup bool
+	// FailIfExists aborts unpacking if a file already exists.
+	FailIfExists bool
+	// Update skips unpacking files identical to the existing ones.
+	Update bool
+	// Base is the archive the existing files were unpacked from, to merge local changes with when unpacking.
+	Base string
+	// ConflictStyle selects how changes that cannot be merged with local changes are written: markers or rej.
+	ConflictStyle string
+	// Prune deletes files in scope of the archive that have no entry in it when unpacking.
+	Prune bool
 }
 
 // Rules defines the filtering and processing rules for file aggregation.
@@ -28,6 +61,8 @@ type Rules struct {
 	Hidden bool
 	// Max defines the maximum number of files to collect.
 	Max int
+	// MaxTokens defines the budget of estimated tokens for all collected files, 0 meaning unlimited.
+	MaxTokens int
 	// Size defines the maximum file size to include in aggregation.
 	Size string
 	// Binary indicates whether to include binary files in the aggregation.
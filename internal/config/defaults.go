@@ -18,6 +18,15 @@ const (
 
 	// DefaultMaxFiles is the default maximum number of files to include in aggregation.
 	DefaultMaxFiles = 1000
+
+	// DefaultMarker is the name of the default marker preset.
+	DefaultMarker = "default"
+
+	// DefaultFormat is the name of the default archive format.
+	DefaultFormat = "aggr"
+
+	// DefaultConflictStyle is the name of the default style for changes that cannot be merged when unpacking.
+	DefaultConflictStyle = "markers"
 )
 
 // DefaultExcludes lists exclude patterns that are always applied.
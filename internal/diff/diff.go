@@ -0,0 +1,230 @@
+// Package diff renders line-based differences between two texts as unified diffs,
+// and merges the changes two texts made to a common base.
+//
+// Lines are matched by their longest common subsequence, after stripping the lines the texts
+// share at their start and end. Texts too large to compare line by line are treated as replaced in full.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around each change.
+const contextLines = 3
+
+// maxCells bounds the size of the table used to match lines, and thereby the memory used.
+const maxCells = 1 << 22
+
+// noNewline marks a last line without a trailing newline.
+const noNewline = "\\ No newline at end of file\n"
+
+// op is a line of a diff: kept (' '), removed ('-') or added ('+').
+type op struct {
+	kind byte
+	line string
+}
+
+// Unified returns the unified diff turning oldText, labelled oldName, into newText, labelled newName.
+// It returns an empty string if the texts are equal.
+func Unified(oldName, newName string, oldText, newText []byte) string {
+	ops := edits(splitLines(string(oldText)), splitLines(string(newText)))
+
+	hunks := hunks(ops)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "--- %s\n+++ %s\n", oldName, newName)
+
+	for _, hunk := range hunks {
+		builder.WriteString(hunk)
+	}
+
+	return builder.String()
+}
+
+// splitLines splits text into lines, each keeping its newline, except possibly the last one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// edits returns the operations turning a into b.
+func edits(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+
+	ops = append(ops, middle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+
+	return ops
+}
+
+// middle returns the operations turning a into b, matching lines by their longest common subsequence.
+func middle(a, b []string) []op {
+	ops := make([]op, 0, len(a)+len(b))
+
+	if len(a)*len(b) > maxCells {
+		for _, line := range a {
+			ops = append(ops, op{'-', line})
+		}
+
+		for _, line := range b {
+			ops = append(ops, op{'+', line})
+		}
+
+		return ops
+	}
+
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	width := len(b) + 1
+	lengths := make([]int32, (len(a)+1)*width)
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			} else {
+				lengths[i*width+j] = max(lengths[(i+1)*width+j], lengths[i*width+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case lengths[(i+1)*width+j] >= lengths[i*width+j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+
+	return ops
+}
+
+// hunks groups the changes in ops into hunks, each surrounded by up to contextLines unchanged lines.
+// Changes separated by at most twice as many unchanged lines share a hunk.
+func hunks(ops []op) []string {
+	var (
+		rendered   []string
+		start, end = -1, -1
+	)
+
+	for index, operation := range ops {
+		if operation.kind == ' ' {
+			continue
+		}
+
+		if start >= 0 && index-end > 2*contextLines {
+			rendered = append(rendered, hunk(ops, start, end))
+			start = -1
+		}
+
+		if start < 0 {
+			start = index
+		}
+
+		end = index + 1
+	}
+
+	if start >= 0 {
+		rendered = append(rendered, hunk(ops, start, end))
+	}
+
+	return rendered
+}
+
+// hunk renders the changes in ops[start:end] with their surrounding context.
+func hunk(ops []op, start, end int) string {
+	start = max(0, start-contextLines)
+	end = min(len(ops), end+contextLines)
+
+	// Line numbers of the first line of the hunk in the old and the new text, counted from 0.
+	oldLine, newLine := 0, 0
+
+	for _, operation := range ops[:start] {
+		if operation.kind != '+' {
+			oldLine++
+		}
+
+		if operation.kind != '-' {
+			newLine++
+		}
+	}
+
+	var (
+		body               strings.Builder
+		oldCount, newCount int
+	)
+
+	for _, operation := range ops[start:end] {
+		if operation.kind != '+' {
+			oldCount++
+		}
+
+		if operation.kind != '-' {
+			newCount++
+		}
+
+		body.WriteByte(operation.kind)
+		body.WriteString(operation.line)
+
+		if !strings.HasSuffix(operation.line, "\n") {
+			body.WriteString("\n" + noNewline)
+		}
+	}
+
+	return fmt.Sprintf("@@ -%s +%s @@\n%s", lineRange(oldLine, oldCount), lineRange(newLine, newCount), body.String())
+}
+
+// lineRange formats the range of count lines starting after line as in a hunk header.
+func lineRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line)
+	case 1:
+		return fmt.Sprintf("%d", line+1)
+	default:
+		return fmt.Sprintf("%d,%d", line+1, count)
+	}
+}
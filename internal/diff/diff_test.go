@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{
+			name:   "equal",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+			want:   "",
+		},
+		{
+			name:   "changed line",
+			before: "a\nb\nc\n",
+			after:  "a\nB\nc\n",
+			want:   "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:   "added to empty",
+			before: "",
+			after:  "x\n",
+			want:   "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name:   "removed all",
+			before: "x\n",
+			after:  "",
+			want:   "--- old\n+++ new\n@@ -1 +0,0 @@\n-x\n",
+		},
+		{
+			name:   "missing final newline on both sides",
+			before: "a\nb",
+			after:  "a\nc",
+			want:   "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n" + noNewline + "+c\n" + noNewline,
+		},
+		{
+			name:   "final newline added",
+			before: "a",
+			after:  "a\n",
+			want:   "--- old\n+++ new\n@@ -1 +1 @@\n-a\n" + noNewline + "+a\n",
+		},
+		{
+			name:   "distant changes in separate hunks",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			after:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name:   "close changes in one hunk",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			after:  "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want:   "--- old\n+++ new\n@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := Unified("old", "new", []byte(test.before), []byte(test.after)); got != test.want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEditsMaxCells(t *testing.T) {
+	t.Parallel()
+
+	// Enough distinct lines on both sides that the table would exceed maxCells, with one line in common.
+	count := 2100
+
+	var before, after []string
+
+	for i := range count {
+		before = append(before, fmt.Sprintf("old %d\n", i))
+		after = append(after, fmt.Sprintf("new %d\n", i))
+	}
+
+	before[count/2], after[count/2] = "common\n", "common\n"
+
+	if len(before)*len(after) <= maxCells {
+		t.Fatalf("%d lines on both sides do not exceed maxCells", count)
+	}
+
+	kinds := map[byte]int{}
+	for _, operation := range edits(before, after) {
+		kinds[operation.kind]++
+	}
+
+	// The texts are treated as replaced in full, without matching the common line.
+	if kinds[' '] != 0 || kinds['-'] != count || kinds['+'] != count {
+		t.Errorf("edits() kept %d, removed %d and added %d lines, want 0, %d and %d",
+			kinds[' '], kinds['-'], kinds['+'], count, count)
+	}
+
+	// Lines shared at the start and end are still kept.
+	got := edits(append([]string{"start\n"}, before...), append([]string{"start\n"}, after...))
+	if got[0] != (op{' ', "start\n"}) {
+		t.Errorf("edits() starts with %+v, want the common first line kept", got[0])
+	}
+
+	unified := Unified("old", "new", []byte(strings.Join(before, "")), []byte(strings.Join(after, "")))
+	if want := fmt.Sprintf("@@ -1,%d +1,%d @@\n", count, count); !strings.Contains(unified, want) {
+		t.Errorf("Unified() does not contain the hunk header %q", want)
+	}
+}
@@ -0,0 +1,114 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+)
+
+// Conflict markers written around the two versions of a conflicting region.
+const (
+	markerStart  = "<<<<<<< "
+	markerMiddle = "=======\n"
+	markerEnd    = ">>>>>>> "
+)
+
+// Merge combines the changes that local and other each made to base, line by line.
+// Regions changed on one side only take that side's version, and regions changed identically on both
+// sides are taken once. Regions changed differently on both sides are conflicts: both versions are written,
+// between conflict markers naming them localLabel and otherLabel.
+// It returns the merged text and the number of conflicts.
+func Merge(base, local, other []byte, localLabel, otherLabel string) ([]byte, int) {
+	baseLines := splitLines(string(base))
+	localLines := splitLines(string(local))
+	otherLines := splitLines(string(other))
+
+	localMatches := matches(baseLines, localLines)
+	otherMatches := matches(baseLines, otherLines)
+
+	var (
+		merged    strings.Builder
+		conflicts int
+	)
+
+	i, j, k := 0, 0, 0
+
+	for {
+		// The next base line kept by both sides ends the current region.
+		next := i
+		for next < len(baseLines) && (localMatches[next] < 0 || otherMatches[next] < 0) {
+			next++
+		}
+
+		localEnd, otherEnd := len(localLines), len(otherLines)
+		if next < len(baseLines) {
+			localEnd, otherEnd = localMatches[next], otherMatches[next]
+		}
+
+		baseRegion, localRegion, otherRegion := baseLines[i:next], localLines[j:localEnd], otherLines[k:otherEnd]
+
+		switch {
+		case slices.Equal(localRegion, baseRegion):
+			writeLines(&merged, otherRegion)
+		case slices.Equal(otherRegion, baseRegion), slices.Equal(localRegion, otherRegion):
+			writeLines(&merged, localRegion)
+		default:
+			conflicts++
+
+			merged.WriteString(markerStart + localLabel + "\n")
+			writeLines(&merged, terminated(localRegion))
+			merged.WriteString(markerMiddle)
+			writeLines(&merged, terminated(otherRegion))
+			merged.WriteString(markerEnd + otherLabel + "\n")
+		}
+
+		if next == len(baseLines) {
+			break
+		}
+
+		merged.WriteString(baseLines[next])
+
+		i, j, k = next+1, localEnd+1, otherEnd+1
+	}
+
+	return []byte(merged.String()), conflicts
+}
+
+// matches returns, for every line of a, the index of the line of b it is matched with, or -1 if it was removed.
+func matches(a, b []string) []int {
+	matched := make([]int, len(a))
+
+	i, j := 0, 0
+
+	for _, operation := range edits(a, b) {
+		switch operation.kind {
+		case ' ':
+			matched[i] = j
+			i++
+			j++
+		case '-':
+			matched[i] = -1
+			i++
+		default:
+			j++
+		}
+	}
+
+	return matched
+}
+
+// writeLines writes lines to builder.
+func writeLines(builder *strings.Builder, lines []string) {
+	for _, line := range lines {
+		builder.WriteString(line)
+	}
+}
+
+// terminated returns lines with a newline added to the last line if it lacks one,
+// so a conflict marker can follow it.
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+
+	return append(slices.Clone(lines[:len(lines)-1]), lines[len(lines)-1]+"\n")
+}
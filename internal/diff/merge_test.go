@@ -0,0 +1,131 @@
+package diff
+
+import "testing"
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		base, local, other string
+		want               string
+		conflicts          int
+	}{
+		{
+			name:  "unchanged",
+			base:  "a\nb\nc\n",
+			local: "a\nb\nc\n",
+			other: "a\nb\nc\n",
+			want:  "a\nb\nc\n",
+		},
+		{
+			name:  "changed locally only",
+			base:  "a\nb\nc\n",
+			local: "a\nB\nc\n",
+			other: "a\nb\nc\n",
+			want:  "a\nB\nc\n",
+		},
+		{
+			name:  "changed in other only",
+			base:  "a\nb\nc\n",
+			local: "a\nb\nc\n",
+			other: "a\nb\nC\n",
+			want:  "a\nb\nC\n",
+		},
+		{
+			name:  "separate changes on both sides",
+			base:  "a\nb\nc\nd\ne\n",
+			local: "A\nb\nc\nd\ne\n",
+			other: "a\nb\nc\nd\nE\n",
+			want:  "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:  "line added and line removed",
+			base:  "a\nb\nc\n",
+			local: "a\nb\nb2\nc\n",
+			other: "b\nc\n",
+			want:  "b\nb2\nc\n",
+		},
+		{
+			name:  "identical changes on both sides",
+			base:  "a\nb\nc\n",
+			local: "a\nX\nc\n",
+			other: "a\nX\nc\n",
+			want:  "a\nX\nc\n",
+		},
+		{
+			name:      "overlapping changes",
+			base:      "a\nb\nc\n",
+			local:     "a\nL\nc\n",
+			other:     "a\nO1\nO2\nc\n",
+			want:      "a\n<<<<<<< local\nL\n=======\nO1\nO2\n>>>>>>> other\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:  "two conflicts",
+			base:  "a\nb\nc\nd\ne\n",
+			local: "A1\nb\nc\nd\nE1\n",
+			other: "A2\nb\nc\nd\nE2\n",
+			want: "<<<<<<< local\nA1\n=======\nA2\n>>>>>>> other\nb\nc\nd\n" +
+				"<<<<<<< local\nE1\n=======\nE2\n>>>>>>> other\n",
+			conflicts: 2,
+		},
+		{
+			name:      "adjacent changes",
+			base:      "a\nb\n",
+			local:     "A\nb\n",
+			other:     "a\nB\n",
+			want:      "<<<<<<< local\nA\nb\n=======\na\nB\n>>>>>>> other\n",
+			conflicts: 1,
+		},
+		{
+			name:      "removed locally, changed in other",
+			base:      "a\nb\nc\n",
+			local:     "a\nc\n",
+			other:     "a\nB\nc\n",
+			want:      "a\n<<<<<<< local\n=======\nB\n>>>>>>> other\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:  "missing final newline kept",
+			base:  "a\nb",
+			local: "A\nb",
+			other: "a\nb",
+			want:  "A\nb",
+		},
+		{
+			name:  "final newline added in other",
+			base:  "a\nb\nc",
+			local: "A\nb\nc",
+			other: "a\nb\nc\n",
+			want:  "A\nb\nc\n",
+		},
+		{
+			name:      "conflict on a last line without newline",
+			base:      "a\nb",
+			local:     "a\nL",
+			other:     "a\nO",
+			want:      "a\n<<<<<<< local\nL\n=======\nO\n>>>>>>> other\n",
+			conflicts: 1,
+		},
+		{
+			name:  "empty base",
+			base:  "",
+			local: "",
+			other: "new\n",
+			want:  "new\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, conflicts := Merge([]byte(test.base), []byte(test.local), []byte(test.other), "local", "other")
+
+			if string(got) != test.want || conflicts != test.conflicts {
+				t.Errorf("Merge() = %q with %d conflicts, want %q with %d", got, conflicts, test.want, test.conflicts)
+			}
+		})
+	}
+}
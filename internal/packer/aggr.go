@@ -0,0 +1,149 @@
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/idelchi/aggr/internal/tree"
+)
+
+// aggrCodec implements FormatAggr, using the prefixes of its aggregator as markers.
+type aggrCodec struct {
+	a *Aggregator
+}
+
+// header returns the format header line naming the format version and the marker.
+func (c aggrCodec) header() string {
+	return c.a.Prefixes.header()
+}
+
+// entry renders e between BEGIN and END markers, with text content escaped.
+func (c aggrCodec) entry(e entry) []byte {
+	body := e.data
+	if isText(e.attrs) {
+		body = c.a.Prefixes.escape(normalizeText(e.data, e.attrs))
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s %s%s\n", c.a.Prefixes.beginPrefix(), formatPath(e.path), e.attrs)
+	buf.Write(body)
+	fmt.Fprintf(&buf, "%s %s\n\n", c.a.Prefixes.endPrefix(), formatPath(e.path))
+
+	return buf.Bytes()
+}
+
+// footer returns the tree, the file count, the estimated number of tokens and, if set, the scope,
+// the part and the archive digest.
+func (c aggrCodec) footer(r report) string {
+	return "\n" + footerStart + "\n" + tree.Generate(r.set, c.a.label(r)).String() + "\n" + r.lines()
+}
+
+// parse reads an archive in FormatAggr.
+// A format header at the start of the stream selects the marker for the rest of the stream.
+//
+//nolint:gocognit,funlen	// Function is complex by design.
+func (c aggrCodec) parse(ctx context.Context, reader *bufio.Reader, chunks chan<- fileChunk) (footer, error) {
+	var summary footer
+
+	prefixes, err := c.a.readHeader(reader)
+	if err != nil {
+		return summary, err
+	}
+
+	begin := prefixes.beginPrefix()
+	end := prefixes.endPrefix()
+
+	var (
+		curPath  string
+		curAttrs Attributes
+		buf      bytes.Buffer
+		inFile   bool
+		crlf     bool
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return summary, ctx.Err()
+		default:
+		}
+
+		line, err := reader.ReadString('\n') // returns line w/ '\n' or EOF
+		if err != nil && err != io.EOF && line == "" {
+			return summary, err
+		}
+
+		switch {
+		case strings.HasPrefix(line, begin):
+			if inFile {
+				return summary, fmt.Errorf("nested %q for %s", begin, curPath)
+			}
+
+			path, attrs, err := splitPayload(line[len(begin):])
+			if err != nil {
+				return summary, err
+			}
+
+			curPath, curAttrs = path, attrs
+
+			// A BEGIN line ending in CRLF means an editor converted the archive's line endings,
+			// which are undone for the body. Stored content only ever uses LF line breaks, except
+			// in files with mixed line endings.
+			crlf = strings.HasSuffix(line, "\r\n")
+
+			buf.Reset()
+
+			inFile = true
+
+		case strings.HasPrefix(line, end):
+			p, _, err := splitPayload(line[len(end):])
+			if err != nil {
+				return summary, err
+			}
+
+			if !inFile || p != curPath {
+				return summary, fmt.Errorf("%q without matching %q for %s", end, begin, p)
+			}
+
+			data := append([]byte(nil), buf.Bytes()...)
+
+			if isText(curAttrs) {
+				if data, err = restoreText(prefixes.unescape(data), curAttrs); err != nil {
+					return summary, fmt.Errorf("%s: %w", curPath, err)
+				}
+			}
+
+			chunks <- fileChunk{path: curPath, attrs: curAttrs, data: data}
+
+			inFile = false
+
+		case inFile:
+			if crlf && strings.HasSuffix(line, "\r\n") {
+				line = line[:len(line)-len("\r\n")] + "\n"
+			}
+
+			buf.WriteString(line) // preserve newlines as before
+
+		case strings.TrimSpace(line) == footerStart:
+			summary.found = true
+
+		case summary.found:
+			summary.parse(line)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if inFile {
+		return summary, fmt.Errorf("unterminated file %q", curPath)
+	}
+
+	return summary, nil
+}
@@ -4,31 +4,42 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/idelchi/aggr/internal/checkers"
-	"github.com/idelchi/aggr/internal/tree"
+	"github.com/idelchi/aggr/internal/tokens"
 	"github.com/idelchi/godyl/pkg/logger"
 	"github.com/idelchi/godyl/pkg/path/file"
 	"github.com/idelchi/godyl/pkg/path/files"
 )
 
-// Prefixes defines the markers and tokens used in the packed stream format.
-type Prefixes struct {
-	// Marker is the common prefix used for BEGIN and END markers.
-	Marker string
-	// Begin is the suffix that indicates the start of a file section.
-	Begin string
-	// End is the suffix that indicates the end of a file section.
-	End string
-	// Escape is the replacement string for Marker when it appears inside file content.
-	Escape string
-}
+// Footer lines written after the last entry.
+const (
+	// footerStart is the line that opens the footer.
+	footerStart = "tree"
+	// footerFiles is the suffix of the footer line holding the file count.
+	footerFiles = " files"
+	// footerTokens is the suffix of the footer line holding the estimated number of tokens.
+	footerTokens = " tokens"
+	// digestPrefix is the prefix of the footer line holding the archive digest.
+	digestPrefix = "sha256: "
+)
+
+// dirPerm is the permission used for directories created during unpacking.
+const dirPerm = 0o755
 
 // Aggregator handles the conversion between individual files and packed streams.
 // It supports both packing multiple files into a single stream and unpacking
@@ -44,70 +55,273 @@ type Aggregator struct {
 	Parallel int
 	// Root specifies the root directory for file operations during packing.
 	Root string
+	// Metadata indicates whether to restore recorded file modes and modification times during unpacking.
+	Metadata bool
+	// Format selects the archive format written by Pack.
+	// When unpacking or verifying, the format is detected from the stream unless set.
+	Format Format
+	// Tokenizer estimates the number of tokens of each entry, reported in the footer.
+	Tokenizer tokens.Tokenizer
+	// Overwrite decides what happens to entries that already exist in the destination when unpacking.
+	Overwrite Policy
+	// Confirm asks whether the existing entry at path may be overwritten, for PolicyPrompt.
+	// If nil, existing entries are treated as with PolicyFail. It must be safe for concurrent use.
+	Confirm func(path string) bool
+	// Update skips unpacking entries identical to the existing entry in the destination, so they are left untouched.
+	Update bool
+	// Staging is the directory Unpack writes to until Commit moves the entries into the destination.
+	// If empty, Unpack writes into the destination directly. It is set by Stage.
+	Staging string
+	// Base holds the content of the regular files of the archive the destination was unpacked from, keyed by path.
+	// If set, existing files are merged with the entries instead of overwritten, and files deleted locally
+	// stay deleted unless their entry changed, see ReadEntries.
+	Base map[string][]byte
+	// ConflictStyle selects how files whose changes cannot be merged with Base are written.
+	ConflictStyle ConflictStyle
+	// Scope is recorded in the footer by Pack, if set.
+	Scope *Scope
+}
+
+// Problem describes an integrity issue found while verifying an archive.
+type Problem struct {
+	// Path is the entry the problem refers to, or empty for archive-wide problems.
+	Path string
+	// Reason describes what is wrong.
+	Reason string
+}
+
+// footer holds the summary read from the end of a packed stream.
+type footer struct {
+	// found is true if the footer was present.
+	found bool
+	// files is the recorded number of files.
+	files int
+	// digest is the recorded archive digest, if any.
+	digest string
+	// scope is the recorded scope, if any.
+	scope *Scope
+	// part is the recorded part of a split archive, if any.
+	part *Part
+}
+
+// parse records the file count, archive digest, scope or part if line holds one.
+// Other footer lines, such as the tree, are ignored.
+func (f *footer) parse(line string) {
+	line = strings.TrimSpace(line)
+
+	if text, ok := strings.CutPrefix(line, scopePrefix); ok {
+		if scope, err := parseScope(text); err == nil {
+			f.scope = scope
+		}
+
+		return
+	}
+
+	if text, ok := strings.CutPrefix(line, partPrefix); ok {
+		if part, err := parsePart(text); err == nil {
+			f.part = part
+		}
+
+		return
+	}
+
+	if digest, ok := strings.CutPrefix(line, digestPrefix); ok {
+		f.digest = digest
+
+		return
+	}
+
+	if count, ok := strings.CutSuffix(line, footerFiles); ok {
+		if n, err := strconv.Atoi(count); err == nil {
+			f.files = n
+		}
+	}
 }
 
 // fileChunk carries one file's data from the parser to a worker.
 type fileChunk struct {
-	path string
-	data []byte
+	path  string
+	attrs Attributes
+	data  []byte
+}
+
+// Unpacked reports the outcome of unpacking.
+type Unpacked struct {
+	// Created holds the entries that did not exist in the destination.
+	Created files.Files
+	// Updated holds the entries that replaced existing ones.
+	Updated files.Files
+	// Unchanged holds the entries that were not written, as they are identical to existing ones if Update is set,
+	// or have only been changed locally since Base.
+	Unchanged files.Files
+	// Merged holds the entries whose changes were merged with local changes to the existing ones, see Base.
+	Merged files.Files
+	// Conflicted holds the files written for entries whose changes conflict with local changes: the files with
+	// conflict markers, or the reject files.
+	Conflicted files.Files
+	// Rejected holds the entries that were refused because their path is unsafe.
+	Rejected []Problem
+	// Entries holds the paths of all entries in the archive, whether they were unpacked or not.
+	Entries []string
+	// Scope is the scope recorded in the archive, if any.
+	Scope *Scope
+	// Parts holds the parts of split archives recorded in the archives, see Part.
+	Parts []Part
 }
 
-// filesSink collects output file paths safely across workers.
+// Written returns the entries that were written, or would be written in dry run mode.
+func (u Unpacked) Written() files.Files {
+	return slices.Concat(u.Created, u.Updated, u.Merged, u.Conflicted)
+}
+
+// Merge adds the outcome of other to u, keeping the scope of u if it has one.
+func (u *Unpacked) Merge(other Unpacked) {
+	u.Created = append(u.Created, other.Created...)
+	u.Updated = append(u.Updated, other.Updated...)
+	u.Unchanged = append(u.Unchanged, other.Unchanged...)
+	u.Merged = append(u.Merged, other.Merged...)
+	u.Conflicted = append(u.Conflicted, other.Conflicted...)
+	u.Rejected = append(u.Rejected, other.Rejected...)
+	u.Entries = append(u.Entries, other.Entries...)
+
+	if u.Scope == nil {
+		u.Scope = other.Scope
+	}
+
+	u.Parts = append(u.Parts, other.Parts...)
+}
+
+// filesSink collects the outcome of unpacking safely across workers.
 type filesSink struct {
-	mu sync.Mutex
-	fs files.Files
+	mu     sync.Mutex
+	result Unpacked
+	// links holds the symbolic link entries, which are written after all other entries, see writeLinks.
+	links []fileChunk
+}
+
+// add records f as created, updated, unchanged, merged or conflicted, depending on kind, in a thread-safe manner.
+func (s *filesSink) add(kind ChangeKind, f file.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch kind {
+	case ChangeNew:
+		s.result.Created.AddFile(f)
+	case ChangeUnchanged:
+		s.result.Unchanged.AddFile(f)
+	case ChangeMerged:
+		s.result.Merged.AddFile(f)
+	case ChangeConflict:
+		s.result.Conflicted.AddFile(f)
+	default:
+		s.result.Updated.AddFile(f)
+	}
 }
 
-// add appends f to the sink in a thread-safe manner.
-func (s *filesSink) add(f file.File) {
+// entry records the path of an entry in the archive, in a thread-safe manner.
+func (s *filesSink) entry(path string) {
 	s.mu.Lock()
-	s.fs.AddFile(f)
+	s.result.Entries = append(s.result.Entries, path)
+	s.mu.Unlock()
+}
+
+// link queues a symbolic link entry to be written after all other entries, in a thread-safe manner.
+func (s *filesSink) link(chunk fileChunk) {
+	s.mu.Lock()
+	s.links = append(s.links, chunk)
+	s.mu.Unlock()
+}
+
+// reject records an entry that was refused, in a thread-safe manner.
+func (s *filesSink) reject(problem Problem) {
+	s.mu.Lock()
+	s.result.Rejected = append(s.result.Rejected, problem)
 	s.mu.Unlock()
 }
 
 // NewAggregator creates a new Aggregator with default configuration.
-// If parallel is ≤ 0, it defaults to 1 worker. The aggregator uses predefined
-// markers for the packed stream format.
+// If parallel is ≤ 0, it defaults to 1 worker. The aggregator uses the default
+// marker for the packed stream format, packs in FormatAggr and estimates tokens approximately.
+// Existing entries are only overwritten when confirmed, see PolicyPrompt.
 func NewAggregator(log *logger.Logger, dry bool, parallel int, root string) *Aggregator {
 	if parallel < 1 {
 		parallel = 1
 	}
 
-	marker := "// === AGGR:"
-
 	return &Aggregator{
-		Prefixes: Prefixes{
-			Marker: marker,
-			Begin:  "BEGIN:",
-			End:    "END:",
-			Escape: marker[:len("// ===")] + "\\ " + marker[len("// === "):], // insert "\" before space
-		},
-		Logger:   log,
-		Dry:      dry,
-		Parallel: parallel,
-		Root:     root,
+		Prefixes:      NewPrefixes(DefaultMarker),
+		Logger:        log,
+		Dry:           dry,
+		Parallel:      parallel,
+		Root:          root,
+		Metadata:      true,
+		Tokenizer:     tokens.Approximate{},
+		Overwrite:     PolicyPrompt,
+		ConflictStyle: ConflictMarkers,
 	}
 }
 
 // Pack writes a packed representation of the file set to the provided writer.
-// It processes all files concurrently and writes them in the packed format.
+// It processes all files concurrently and writes them in the configured format,
+// preceded by the format header.
 func (a *Aggregator) Pack(set files.Files, writer io.Writer) error {
-	if !a.Dry {
-		if err := a.packFiles(set, writer); err != nil {
+	summary := report{set: set, tokens: make(map[string]int, len(set)), scope: a.Scope}
+
+	codec := a.codec(a.Format)
+
+	if a.Dry {
+		summary.tokens = a.estimate(set)
+	} else {
+		if _, err := io.WriteString(writer, codec.header()); err != nil {
 			return err
 		}
+
+		separator := separatorOf(codec)
+		entries := newManifest()
+
+		err := a.packFiles(codec, set, func(result packed) error {
+			if entries.count > 0 {
+				if _, err := io.WriteString(writer, separator); err != nil {
+					return err
+				}
+			}
+
+			if _, err := writer.Write(result.block); err != nil {
+				return err
+			}
+
+			entries.add(result.file.Path(), result.sum)
+			summary.tokens[result.file.Path()] = result.tokens
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		summary.digest = entries.digest()
 	}
 
-	return a.writeFooter(set, writer)
+	_, err := io.WriteString(writer, codec.footer(summary))
+
+	return err
 }
 
-// Unpack reads a packed stream and recreates the original files under the destination directory.
-// It returns the list of files that were written (or would be written in dry run mode).
+// Unpack reads a packed stream and recreates the original files under the destination directory,
+// or under the staging directory if Stage was called. It stops when ctx is cancelled.
+// It reports the entries that were created, updated or merged (or would be in dry run mode), the entries that
+// were left unchanged, and the entries that were refused because their path is unsafe, such as absolute paths
+// or paths escaping the destination, along with the paths of all entries and the scope recorded in the archive.
 // The checkers parameter allows filtering which files to extract.
-func (a *Aggregator) Unpack(reader file.File, dst string, chk checkers.Checkers) (files.Files, error) {
+func (a *Aggregator) Unpack(
+	ctx context.Context,
+	reader io.Reader,
+	dst string,
+	chk checkers.Checkers,
+) (Unpacked, error) {
 	var sink filesSink
 
-	errGroup, ctx := errgroup.WithContext(context.Background())
+	errGroup, ctx := errgroup.WithContext(ctx)
 	errGroup.SetLimit(a.Parallel + 1)
 
 	const channelBufferFactor = 2
@@ -131,42 +345,169 @@ func (a *Aggregator) Unpack(reader file.File, dst string, chk checkers.Checkers)
 	errGroup.Go(func() error {
 		defer close(chunks)
 
-		return a.parseStream(ctx, reader, chunks)
+		summary, err := a.parseStream(ctx, reader, chunks)
+		sink.result.Scope = summary.scope
+
+		if summary.part != nil {
+			sink.result.Parts = []Part{*summary.part}
+		}
+
+		return err
 	})
 
 	if err := errGroup.Wait(); err != nil {
-		return nil, err
+		return sink.result, err
 	}
 
-	return sink.fs, nil
+	if err := a.writeLinks(dst, &sink); err != nil {
+		return sink.result, err
+	}
+
+	return sink.result, nil
 }
 
-// packFiles packs every file in set and writes each block to w in order.
-func (a *Aggregator) packFiles(set files.Files, writer io.Writer) error {
-	errGroup, _ := errgroup.WithContext(context.Background())
-	errGroup.SetLimit(a.Parallel)
+// Verify re-parses a packed stream and checks each entry against its recorded checksum,
+// and the whole archive against the digest and file count recorded in the footer.
+// If root is not empty, each entry is also compared with the corresponding file under root.
+// Structural damage, such as a truncated entry, is returned as an error.
+func (a *Aggregator) Verify(reader io.Reader, root string) ([]Problem, error) {
+	var (
+		problems []Problem
+		summary  footer
+	)
 
-	blocks := make([][]byte, len(set))
+	errGroup, ctx := errgroup.WithContext(context.Background())
+	chunks := make(chan fileChunk, a.Parallel)
 
-	for index, file := range set {
-		errGroup.Go(func() error {
-			b, err := a.packFile(file)
-			if err != nil {
-				return err
-			}
+	errGroup.Go(func() error {
+		defer close(chunks)
 
-			blocks[index] = b
+		var err error
 
-			return nil
-		})
+		summary, err = a.parseStream(ctx, reader, chunks)
+
+		return err
+	})
+
+	entries := newManifest()
+
+	for chunk := range chunks {
+		data, err := decode(chunk)
+		if err != nil {
+			problems = append(problems, Problem{Path: chunk.path, Reason: err.Error()})
+
+			continue
+		}
+
+		sum := checksum(data)
+		entries.add(chunk.path, sum)
+
+		switch recorded, ok := chunk.attrs["sha256"]; {
+		case !ok:
+			problems = append(problems, Problem{Path: chunk.path, Reason: "no checksum recorded"})
+		case recorded != sum:
+			problems = append(problems, Problem{Path: chunk.path, Reason: "checksum mismatch: content was modified"})
+		}
+
+		if err := validatePath(chunk.path); err != nil {
+			problems = append(problems, Problem{Path: chunk.path, Reason: "unsafe path: " + err.Error()})
+
+			continue
+		}
+
+		if root != "" {
+			problems = append(problems, compareWithDisk(chunk, data, root)...)
+		}
 	}
 
 	if err := errGroup.Wait(); err != nil {
-		return err
+		return problems, fmt.Errorf("archive is truncated or malformed: %w", err)
 	}
 
-	for _, b := range blocks {
-		if _, err := writer.Write(b); err != nil {
+	switch {
+	case !summary.found:
+		problems = append(problems, Problem{Reason: "footer is missing: archive may be truncated"})
+	case summary.files != entries.count:
+		problems = append(problems, Problem{
+			Reason: fmt.Sprintf("footer records %d files, archive contains %d", summary.files, entries.count),
+		})
+	case summary.digest == "":
+		problems = append(problems, Problem{Reason: "no archive digest recorded"})
+	case summary.digest != entries.digest():
+		problems = append(problems, Problem{Reason: "archive digest mismatch: entries were added, removed or modified"})
+	}
+
+	return problems, nil
+}
+
+// compareWithDisk compares the unpacked content of an entry with the entry at the same path under root.
+func compareWithDisk(chunk fileChunk, data []byte, root string) []Problem {
+	onDisk, err := readEntry(file.New(root, chunk.path), chunk.attrs["type"])
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return []Problem{{Path: chunk.path, Reason: fmt.Sprintf("missing in %q", root)}}
+	case err != nil:
+		return []Problem{{Path: chunk.path, Reason: err.Error()}}
+	case !bytes.Equal(onDisk, data):
+		return []Problem{{Path: chunk.path, Reason: fmt.Sprintf("differs from %q", root)}}
+	}
+
+	return nil
+}
+
+// readEntry reads the entry at path from disk in the representation returned by decode
+// for an entry of the given type.
+func readEntry(path file.File, kind string) ([]byte, error) {
+	switch kind {
+	case typeSymlink:
+		target, err := os.Readlink(path.Path())
+
+		return []byte(target), err
+	case typeDir:
+		info, err := os.Stat(path.Path())
+		if err == nil && !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", path)
+		}
+
+		return nil, err
+	default:
+		return path.Read()
+	}
+}
+
+// packed is the result of packing a single file.
+type packed struct {
+	file   file.File
+	block  []byte
+	sum    string
+	tokens int
+	err    error
+}
+
+// reorderWindowFactor multiplied by the number of workers gives the number of packed blocks
+// that may wait to be written, which bounds the memory used while packing.
+const reorderWindowFactor = 2
+
+// packFiles packs every file in set and passes each block, rendered by codec, to emit in order.
+// Blocks are streamed through a bounded reorder window: workers pack files concurrently,
+// while blocks are emitted in the order of set as soon as they and all preceding blocks are ready.
+// Packing stops at the first error, either from a worker or from emit.
+func (a *Aggregator) packFiles(codec codec, set files.Files, emit func(result packed) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slots := make(chan chan packed, a.Parallel*reorderWindowFactor)
+
+	go a.schedule(ctx, codec, set, slots)
+
+	for slot := range slots {
+		result := <-slot
+		if result.err != nil {
+			return result.err
+		}
+
+		if err := emit(result); err != nil {
 			return err
 		}
 	}
@@ -174,129 +515,279 @@ func (a *Aggregator) packFiles(set files.Files, writer io.Writer) error {
 	return nil
 }
 
-// packFile returns the packed representation of a single file.
-func (a *Aggregator) packFile(inputFile file.File) ([]byte, error) {
+// schedule packs the files in set on up to Parallel workers.
+// Each file receives a slot, queued in order on slots, that its worker fills with the result.
+// Scheduling blocks while slots is full and stops when ctx is cancelled.
+func (a *Aggregator) schedule(ctx context.Context, codec codec, set files.Files, slots chan<- chan packed) {
+	defer close(slots)
+
+	var workers errgroup.Group
+
+	workers.SetLimit(a.Parallel)
+
+	for _, file := range set {
+		slot := make(chan packed, 1)
+
+		select {
+		case slots <- slot:
+		case <-ctx.Done():
+			return
+		}
+
+		workers.Go(func() error {
+			packedEntry, err := a.packFile(file)
+			if err != nil {
+				slot <- packed{err: err}
+
+				return nil
+			}
+
+			slot <- packed{
+				file:   file,
+				block:  codec.entry(packedEntry),
+				sum:    packedEntry.attrs["sha256"],
+				tokens: a.Tokenizer.Count(packedEntry.data),
+			}
+
+			return nil
+		})
+	}
+}
+
+// packFile reads a single entry and its attributes, ready to be rendered in an archive format.
+func (a *Aggregator) packFile(inputFile file.File) (entry, error) {
 	realPath := file.New(a.Root, inputFile.Path())
 
-	data, err := realPath.Read()
+	info, err := os.Lstat(realPath.Path())
 	if err != nil {
-		return nil, fmt.Errorf("read %s: %w", realPath, err)
+		return entry{}, fmt.Errorf("stat %s: %w", realPath, err)
 	}
 
-	var buf bytes.Buffer
+	var (
+		attrs   Attributes
+		content []byte
+	)
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		attrs, err = packSymlink(realPath)
+	case info.IsDir():
+		attrs = Attributes{"type": typeDir, "sha256": checksum(nil)}
+	default:
+		attrs, content, err = a.packRegular(realPath)
+	}
 
-	fmt.Fprintf(&buf, "%s %s\n", a.Prefixes.beginPrefix(), inputFile.Path())
-	buf.Write(a.escape(canonical(data)))
-	fmt.Fprintf(&buf, "%s %s\n\n", a.Prefixes.endPrefix(), inputFile.Path())
+	if err != nil {
+		return entry{}, err
+	}
 
-	return buf.Bytes(), nil
+	if attrs["type"] != typeSymlink {
+		attrs["mode"] = fmt.Sprintf("%04o", info.Mode().Perm())
+		attrs["mtime"] = info.ModTime().UTC().Format(time.RFC3339Nano)
+	}
+
+	return entry{path: inputFile.Path(), attrs: attrs, data: content}, nil
 }
 
-// writeFooter appends the tree and file count summary.
-func (a *Aggregator) writeFooter(set files.Files, writer io.Writer) error {
-	if _, err := io.WriteString(writer, "\ntree\n"); err != nil {
-		return err
+// packRegular returns the attributes and the content of a regular file.
+func (a *Aggregator) packRegular(realPath file.File) (Attributes, []byte, error) {
+	data, err := realPath.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", realPath, err)
 	}
 
-	if _, err := io.WriteString(writer, tree.Generate(set, a.Dry).String()); err != nil {
-		return err
+	attrs := Attributes{"sha256": checksum(data)}
+
+	// Binary content is not line-oriented, so it is stored encoded instead of as text.
+	if checkers.NewBinary().Check("", realPath.Path()) != nil {
+		attrs["encoding"] = encodingBase64
+
+		return attrs, encodeBase64(data), nil
 	}
 
-	_, err := io.WriteString(writer, fmt.Sprintf("\n%d files\n", len(set)))
+	return attrs, data, nil
+}
 
-	return err
+// packSymlink returns the attributes of a symbolic link entry.
+// The link target is its content, so the checksum is computed over the target.
+func packSymlink(realPath file.File) (Attributes, error) {
+	target, err := os.Readlink(realPath.Path())
+	if err != nil {
+		return nil, fmt.Errorf("readlink %s: %w", realPath, err)
+	}
+
+	return Attributes{"type": typeSymlink, "target": target, "sha256": checksum([]byte(target))}, nil
 }
 
 // parseStream reads a packed stream and sends file chunks to the provided channel.
-//
-//nolint:gocognit	// Function is complex by design.
-func (a *Aggregator) parseStream(ctx context.Context, reader file.File, chunks chan<- fileChunk) error {
-	openFile, err := reader.Open()
+// Compressed streams are decompressed transparently, and the format is detected unless Format is set.
+// It returns the summary found in the footer, if any.
+func (a *Aggregator) parseStream(ctx context.Context, reader io.Reader, chunks chan<- fileChunk) (footer, error) {
+	input, err := decompress(bufio.NewReader(reader))
 	if err != nil {
-		return err
+		return footer{}, err
 	}
-	defer openFile.Close()
 
-	bufReader := bufio.NewReader(openFile)
-	begin := a.Prefixes.beginPrefix()
-	end := a.Prefixes.endPrefix()
+	bufReader := bufio.NewReader(input)
 
-	var (
-		curPath string
-		buf     bytes.Buffer
-		inFile  bool
-	)
+	format := a.Format
+	if format == "" {
+		format = detectFormat(bufReader)
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	return a.codec(format).parse(ctx, bufReader, chunks)
+}
 
-		line, err := bufReader.ReadString('\n') // returns line w/ '\n' or EOF
-		if err != nil && err != io.EOF && line == "" {
-			return err
+// decode restores the original content of a parsed file chunk.
+// The content of a symbolic link is its target, directories have no content.
+func decode(chunk fileChunk) ([]byte, error) {
+	switch kind := chunk.attrs["type"]; kind {
+	case "":
+	case typeSymlink:
+		return []byte(chunk.attrs["target"]), nil
+	case typeDir:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported entry type %q", chunk.path, kind)
+	}
+
+	switch encoding := chunk.attrs["encoding"]; encoding {
+	case "":
+		return chunk.data, nil
+	case encodingBase64:
+		data, err := decodeBase64(chunk.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", chunk.path, err)
 		}
 
-		switch {
-		case strings.HasPrefix(line, begin):
-			if inFile {
-				return fmt.Errorf("nested %q for %s", begin, curPath)
-			}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported encoding %q", chunk.path, encoding)
+	}
+}
 
-			curPath = strings.TrimSpace(line[len(begin):])
+// writeChunk writes one unpacked entry to disk unless Dry is true.
+// Symbolic links are only queued, to be validated and written by writeLinks once all other entries are written.
+func (a *Aggregator) writeChunk(chunk fileChunk, dst string, checkers checkers.Checkers, sink *filesSink) error {
+	data, err := decode(chunk)
+	if err != nil {
+		return err
+	}
 
-			buf.Reset()
+	sink.entry(chunk.path)
 
-			inFile = true
+	if err := validatePath(chunk.path); err != nil {
+		a.reject(sink, chunk.path, err)
 
-		case strings.HasPrefix(line, end):
-			p := strings.TrimSpace(line[len(end):])
-			if !inFile || p != curPath {
-				return fmt.Errorf("%q without matching %q for %s", end, begin, p)
-			}
+		return nil
+	}
 
-			dataCopy := append([]byte(nil), buf.Bytes()...)
-			chunks <- fileChunk{path: curPath, data: dataCopy}
+	if err := checkers.Check("", chunk.path); err != nil {
+		a.Logger.Debugf("  - %s: %v", chunk.path, err)
 
-			inFile = false
+		return nil
+	}
 
-		default:
-			if inFile {
-				buf.WriteString(line) // preserve newlines as before
-			}
-		}
+	if chunk.attrs["type"] == typeSymlink {
+		if err := validateSymlink(chunk.path, string(data)); err != nil {
+			a.reject(sink, chunk.path, err)
 
-		if err == io.EOF {
-			break
+			return nil
 		}
-	}
 
-	if inFile {
-		return fmt.Errorf("unterminated file %q", curPath)
+		sink.link(chunk)
+
+		return nil
 	}
 
-	return nil
+	return a.writeEntry(chunk, data, dst, sink)
 }
 
-// writeChunk writes one unpacked file to disk unless Dry is true.
-func (a *Aggregator) writeChunk(chunk fileChunk, dst string, checkers checkers.Checkers, sink *filesSink) error {
-	data := canonical(a.unescape(chunk.data))
-
-	if err := checkers.Check("", chunk.path); err != nil {
-		a.Logger.Debugf("  - %s: %v", chunk.path, err)
+// writeEntry writes an entry that passed the checks of writeChunk, with its decoded content data.
+func (a *Aggregator) writeEntry(chunk fileChunk, data []byte, dst string, sink *filesSink) error {
+	if err := validateParents(chunk.path, chunk.attrs["type"], dst, a.Staging); err != nil {
+		a.reject(sink, chunk.path, err)
 
 		return nil
 	}
 
 	outputFile := file.New(dst, chunk.path)
-	sink.add(outputFile)
+
+	kind := ChangeNew
+	if _, err := os.Lstat(outputFile.Path()); err == nil {
+		kind = ChangeModified
+	}
+
+	if kind == ChangeModified && a.Update {
+		if onDisk, err := readEntry(outputFile, chunk.attrs["type"]); err == nil && bytes.Equal(onDisk, data) {
+			sink.add(ChangeUnchanged, outputFile)
+
+			return nil
+		}
+	}
+
+	// A file deleted locally stays deleted if its entry did not change since Base, as for any other local edit.
+	if kind == ChangeNew && a.Base != nil && chunk.attrs["type"] == "" {
+		if base, ok := a.Base[chunk.path]; ok && bytes.Equal(base, data) {
+			sink.add(ChangeUnchanged, outputFile)
+
+			return nil
+		}
+	}
+
+	if kind == ChangeModified && a.Base != nil && chunk.attrs["type"] == "" {
+		result, err := a.merge(chunk, outputFile, data)
+		if err != nil {
+			return err
+		}
+
+		if result.kind != "" {
+			return a.writeMerged(sink, dst, result)
+		}
+	}
+
+	if proceed, err := a.resolveConflict(outputFile.Path(), chunk.attrs["type"]); err != nil || !proceed {
+		return err
+	}
+
+	sink.add(kind, outputFile)
 
 	if a.Dry {
 		return nil
 	}
 
+	if a.Staging != "" {
+		outputFile = file.New(a.Staging, chunk.path)
+	}
+
+	switch chunk.attrs["type"] {
+	case typeSymlink:
+		return writeSymlink(outputFile, string(data))
+	case typeDir:
+		if err := os.MkdirAll(outputFile.Path(), dirPerm); err != nil {
+			return fmt.Errorf("create %s: %w", outputFile, err)
+		}
+	default:
+		if err := writeFile(outputFile, data); err != nil {
+			return err
+		}
+	}
+
+	if !a.Metadata {
+		return nil
+	}
+
+	return restoreMetadata(outputFile.Path(), restorable(chunk.attrs, data))
+}
+
+// reject reports an entry that is not written because its path is unsafe.
+func (a *Aggregator) reject(sink *filesSink, path string, err error) {
+	a.Logger.Warnf("refusing to unpack %q: %v", path, err)
+	sink.reject(Problem{Path: path, Reason: err.Error()})
+}
+
+// writeFile creates or truncates outputFile and writes data to it.
+func writeFile(outputFile file.File, data []byte) error {
 	if err := outputFile.Create(); err != nil {
 		return fmt.Errorf("create %s: %w", outputFile, err)
 	}
@@ -305,44 +796,89 @@ func (a *Aggregator) writeChunk(chunk fileChunk, dst string, checkers checkers.C
 	if err != nil {
 		return fmt.Errorf("open %s: %w", outputFile, err)
 	}
-	defer fileWriter.Close()
 
-	_, err = fileWriter.Write(data)
+	if _, err := fileWriter.Write(data); err != nil {
+		fileWriter.Close()
 
-	return err
+		return fmt.Errorf("write %s: %w", outputFile, err)
+	}
+
+	if err := fileWriter.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", outputFile, err)
+	}
+
+	return nil
 }
 
-// beginPrefix returns the full BEGIN marker used during parsing.
-func (p Prefixes) beginPrefix() string { return fmt.Sprintf("%s %s", p.Marker, p.Begin) }
+// writeSymlink creates outputFile as a symbolic link to target, replacing any existing entry.
+func writeSymlink(outputFile file.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(outputFile.Path()), dirPerm); err != nil {
+		return fmt.Errorf("create parent of %s: %w", outputFile, err)
+	}
 
-// endPrefix returns the full END marker used during parsing.
-func (p Prefixes) endPrefix() string { return fmt.Sprintf("%s %s", p.Marker, p.End) }
+	if err := os.Remove(outputFile.Path()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("replace %s: %w", outputFile, err)
+	}
 
-func (a *Aggregator) escape(data []byte) []byte {
-	lines := strings.Split(string(data), "\n")
-	for i, line := range lines {
-		if strings.HasPrefix(strings.TrimLeft(line, " \t"), a.Prefixes.Marker) {
-			lines[i] = strings.Replace(line, a.Prefixes.Marker, a.Prefixes.Escape, 1)
-		}
+	if err := os.Symlink(target, outputFile.Path()); err != nil {
+		return fmt.Errorf("symlink %s: %w", outputFile, err)
 	}
 
-	return []byte(strings.Join(lines, "\n"))
+	return nil
 }
 
-func (a *Aggregator) unescape(data []byte) []byte {
-	lines := strings.Split(string(data), "\n")
-	for i, line := range lines {
-		if strings.HasPrefix(strings.TrimLeft(line, " \t"), a.Prefixes.Escape) {
-			lines[i] = strings.Replace(line, a.Prefixes.Escape, a.Prefixes.Marker, 1)
-		}
+// validateSymlink refuses link targets that are empty, absolute or escape the destination directory as written.
+// Targets escaping through other links are refused by writeLinks.
+func validateSymlink(path, target string) error {
+	if target == "" {
+		return errors.New("empty symbolic link target")
+	}
+
+	if filepath.IsAbs(target) || !filepath.IsLocal(filepath.Join(filepath.Dir(path), target)) {
+		return fmt.Errorf("symbolic link target %q escapes the destination", target)
 	}
 
-	return []byte(strings.Join(lines, "\n"))
+	return nil
+}
+
+// restorable returns the metadata of attrs to restore on an entry unpacked with content data. The recorded
+// modification time is dropped unless data matches the recorded checksum: content edited since packing keeps
+// the time it is written at, so build tools and file watchers see the change.
+func restorable(attrs Attributes, data []byte) Attributes {
+	if recorded, ok := attrs["sha256"]; ok && recorded == checksum(data) {
+		return attrs
+	}
+
+	restored := maps.Clone(attrs)
+	delete(restored, "mtime")
+
+	return restored
 }
 
-// canonical trims all trailing newlines and appends one '\n'.
-func canonical(data []byte) []byte {
-	data = bytes.TrimRight(data, "\n")
+// restoreMetadata applies the mode and modification time recorded in attrs to path.
+// Missing attributes, as in archives written by older versions, are left at their defaults.
+func restoreMetadata(path string, attrs Attributes) error {
+	if mode, ok := attrs["mode"]; ok {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("%s: invalid mode %q", path, mode)
+		}
+
+		if err := os.Chmod(path, os.FileMode(perm).Perm()); err != nil {
+			return fmt.Errorf("chmod %s: %w", path, err)
+		}
+	}
+
+	if mtime, ok := attrs["mtime"]; ok {
+		modTime, err := time.Parse(time.RFC3339Nano, mtime)
+		if err != nil {
+			return fmt.Errorf("%s: invalid mtime %q", path, mtime)
+		}
 
-	return append(data, '\n')
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return fmt.Errorf("chtimes %s: %w", path, err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,42 @@
+package packer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnpackModificationTime(t *testing.T) {
+	t.Parallel()
+
+	recorded := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	a := newTestAggregator(t)
+
+	archive := packContents(t, a, map[string]string{"edited.txt": "original\n", "kept.txt": "kept\n"})
+	archive = strings.ReplaceAll(archive, "original", "edited")
+
+	// Pin the recorded times, so that the current time cannot be mistaken for them.
+	for _, line := range strings.Split(archive, "\n") {
+		if _, rest, ok := strings.Cut(line, "mtime="); ok {
+			stamp, _, _ := strings.Cut(rest, " ")
+			archive = strings.ReplaceAll(archive, stamp, recorded.Format(time.RFC3339Nano))
+		}
+	}
+
+	dst := t.TempDir()
+	unpack(t, newTestAggregator(t), dst, archive)
+
+	for path, restored := range map[string]bool{"edited.txt": false, "kept.txt": true} {
+		info, err := os.Stat(filepath.Join(dst, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := info.ModTime().Equal(recorded); got != restored {
+			t.Errorf("%s: modification time %v, restored = %v, want %v", path, info.ModTime(), got, restored)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package packer
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Entry types recorded in the "type" attribute. Regular files carry no type attribute.
+const (
+	// typeSymlink marks a symbolic link, whose target is stored in the "target" attribute.
+	typeSymlink = "symlink"
+	// typeDir marks an empty directory.
+	typeDir = "dir"
+)
+
+// Attributes holds the optional key=value metadata attached to a BEGIN marker.
+//
+// They are rendered after the path, enclosed in square brackets:
+//
+//	// === AGGR: BEGIN: main.go [newlines=0]
+//
+// Values containing spaces, quotes, brackets or non-printable characters are Go-quoted.
+type Attributes map[string]string
+
+// String renders the attributes as " [key=value ...]" with keys in sorted order.
+// It returns an empty string if there are no attributes.
+func (a Attributes) String() string {
+	if len(a) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	pairs := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+quoteValue(a[key]))
+	}
+
+	return " [" + strings.Join(pairs, " ") + "]"
+}
+
+// Int returns the integer value stored under key, or def if the key is absent.
+func (a Attributes) Int(key string, def int) (int, error) {
+	value, ok := a[key]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("attribute %q: invalid integer %q", key, value)
+	}
+
+	return n, nil
+}
+
+// formatPath renders a path for a BEGIN or END marker.
+// Common paths, including non-ASCII ones, are written as is. Paths that would be ambiguous,
+// because they are empty, start with a quote, have leading or trailing whitespace, contain
+// non-printable characters such as newlines, or contain " [", are Go-quoted.
+func formatPath(path string) string {
+	if path == "" || strings.HasPrefix(path, `"`) || strings.TrimSpace(path) != path ||
+		strings.Contains(path, " [") || strings.IndexFunc(path, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return strconv.Quote(path)
+	}
+
+	return path
+}
+
+// parsePath returns the path rendered by formatPath, unquoting it if needed.
+func parsePath(text string) (string, error) {
+	if !strings.HasPrefix(text, `"`) {
+		return text, nil
+	}
+
+	path, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("malformed quoted path %q", text)
+	}
+
+	return path, nil
+}
+
+// splitPayload separates the text following a BEGIN or END marker into the path and its attributes.
+// Quoted paths are unquoted; unquoted paths are trimmed of surrounding whitespace, as in older archives.
+func splitPayload(payload string) (string, Attributes, error) {
+	payload = strings.TrimPrefix(strings.TrimRight(payload, "\r\n"), " ")
+
+	if !strings.HasPrefix(payload, `"`) {
+		path, attrs := splitAttributes(strings.TrimSpace(payload))
+
+		return path, attrs, nil
+	}
+
+	quoted, err := strconv.QuotedPrefix(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed quoted path in %q", payload)
+	}
+
+	path, _ := strconv.Unquote(quoted)
+
+	rest := strings.TrimSpace(payload[len(quoted):])
+	if rest == "" {
+		return path, Attributes{}, nil
+	}
+
+	attrs, err := parseAttributes(rest)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, attrs, nil
+}
+
+// splitAttributes separates an unquoted marker payload into the path and its attributes.
+// The attribute block is the leftmost " [...]" suffix that parses cleanly, so paths that
+// merely contain brackets are left intact. Payloads without attributes return an empty set.
+func splitAttributes(payload string) (string, Attributes) {
+	for offset := 0; ; {
+		index := strings.Index(payload[offset:], " [")
+		if index < 0 {
+			return payload, Attributes{}
+		}
+
+		index += offset
+
+		if attrs, err := parseAttributes(payload[index+1:]); err == nil {
+			return payload[:index], attrs
+		}
+
+		offset = index + 1
+	}
+}
+
+// parseAttributes parses a "[key=value ...]" block.
+func parseAttributes(block string) (Attributes, error) {
+	if !strings.HasPrefix(block, "[") || !strings.HasSuffix(block, "]") {
+		return nil, fmt.Errorf("malformed attribute block %q", block)
+	}
+
+	rest := block[1 : len(block)-1]
+	attrs := Attributes{}
+
+	for rest = strings.TrimLeft(rest, " "); rest != ""; rest = strings.TrimLeft(rest, " ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \"[]") {
+			return nil, fmt.Errorf("malformed attribute in %q", block)
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("malformed attribute value in %q: %w", block, err)
+			}
+
+			unquoted, _ := strconv.Unquote(quoted)
+
+			attrs[key] = unquoted
+			rest = value[len(quoted):]
+		} else {
+			end := strings.IndexByte(value, ' ')
+			if end < 0 {
+				end = len(value)
+			}
+
+			attrs[key] = value[:end]
+			rest = value[end:]
+		}
+
+		if rest != "" && !strings.HasPrefix(rest, " ") {
+			return nil, fmt.Errorf("malformed attribute in %q", block)
+		}
+	}
+
+	return attrs, nil
+}
+
+// quoteValue returns value unchanged if it can be represented bare, otherwise Go-quoted.
+func quoteValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \"[]") ||
+		strings.IndexFunc(value, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
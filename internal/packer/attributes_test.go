@@ -0,0 +1,84 @@
+package packer
+
+import (
+	"maps"
+	"math"
+	"strings"
+	"testing"
+)
+
+// oddNames holds file names that need quoting or careful parsing in entry markers.
+//
+//nolint:gochecknoglobals	// Shared test data.
+var oddNames = []string{
+	" leading space.txt",
+	"trailing space.txt ",
+	"ünïcødé/日本語.md",
+	"brackets [mode=0644].txt",
+	"brackets[].txt",
+	`"quoted.txt`,
+	`inner "quotes".txt`,
+	"new\nline.txt",
+	"tab\tseparated.txt",
+	"dir with spaces/ nested .go",
+}
+
+func TestFormatPathRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	attrs := Attributes{"mode": "0644", "sha256": "0"}
+
+	for _, name := range oddNames {
+		for _, suffix := range []string{"", attrs.String()} {
+			path, got, err := splitPayload(" " + formatPath(name) + suffix + "\n")
+			if err != nil {
+				t.Errorf("splitPayload(formatPath(%q)) error = %v", name, err)
+
+				continue
+			}
+
+			if path != name {
+				t.Errorf("splitPayload(formatPath(%q)) path = %q", name, path)
+			}
+
+			if suffix != "" && !maps.Equal(got, attrs) {
+				t.Errorf("splitPayload(formatPath(%q)) attributes = %v, want %v", name, got, attrs)
+			}
+		}
+	}
+}
+
+func TestOddNamesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	contents := make(map[string]string, len(oddNames))
+	for _, name := range oddNames {
+		contents[name] = "content of " + name + "\n"
+	}
+
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			a := newTestAggregator(t)
+			a.Format = format
+
+			packContents(t, a, contents)
+
+			collected, err := walk(a.Logger, a.Root, []string{"**"}, nil, math.MaxInt)
+			if err != nil {
+				t.Fatalf("walk() error = %v", err)
+			}
+
+			var stream strings.Builder
+
+			if err := a.Pack(collected, &stream); err != nil {
+				t.Fatalf("Pack() error = %v", err)
+			}
+
+			if got := readEntries(t, a, stream.String()); !maps.Equal(got, contents) {
+				t.Errorf("entries differ after round-trip:\ngot  %q\nwant %q", got, contents)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/idelchi/aggr/internal/diff"
+	"github.com/idelchi/godyl/pkg/path/file"
+)
+
+// ChangeKind classifies how an archive entry differs from the corresponding entry on disk.
+type ChangeKind string
+
+const (
+	// ChangeNew is an entry that does not exist on disk.
+	ChangeNew ChangeKind = "new"
+	// ChangeModified is an entry that differs from the one on disk.
+	ChangeModified ChangeKind = "modified"
+	// ChangeUnchanged is an entry that is identical to the one on disk.
+	ChangeUnchanged ChangeKind = "unchanged"
+	// ChangeMissing is a file on disk that is not in the archive.
+	ChangeMissing ChangeKind = "missing"
+	// ChangeMerged is an entry whose changes were merged with the local changes to the one on disk.
+	ChangeMerged ChangeKind = "merged"
+	// ChangeConflict is an entry whose changes conflict with the local changes to the one on disk.
+	ChangeConflict ChangeKind = "conflict"
+)
+
+// Change describes how an archive entry differs from the corresponding entry under the root directory.
+type Change struct {
+	// Path is the path of the entry.
+	Path string
+	// Kind classifies the change.
+	Kind ChangeKind
+	// Diff is the unified diff from the entry on disk to the archive entry, for new and modified entries.
+	Diff string
+}
+
+// Diff parses a packed stream and compares each entry with the corresponding entry under root.
+// It returns one change per entry, in archive order, and the scope recorded in the archive, if any.
+// Entries with unsafe paths are reported and skipped.
+func (a *Aggregator) Diff(ctx context.Context, reader io.Reader, root string) ([]Change, *Scope, error) {
+	var (
+		changes []Change
+		summary footer
+	)
+
+	errGroup, ctx := errgroup.WithContext(ctx)
+	chunks := make(chan fileChunk, a.Parallel)
+
+	errGroup.Go(func() error {
+		defer close(chunks)
+
+		var err error
+
+		summary, err = a.parseStream(ctx, reader, chunks)
+
+		return err
+	})
+
+	var errs []error
+
+	for chunk := range chunks {
+		if err := validatePath(chunk.path); err != nil {
+			a.Logger.Warnf("skipping %q: %v", chunk.path, err)
+
+			continue
+		}
+
+		data, err := decode(chunk)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		changes = append(changes, compare(chunk, data, root))
+	}
+
+	errs = append(errs, errGroup.Wait())
+
+	return changes, summary.scope, errors.Join(errs...)
+}
+
+// compare compares the unpacked content of an entry with the entry at the same path under root.
+func compare(chunk fileChunk, data []byte, root string) Change {
+	change := Change{Path: chunk.path, Kind: ChangeModified}
+
+	onDisk, err := readEntry(file.New(root, chunk.path), chunk.attrs["type"])
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		change.Kind = ChangeNew
+		change.Diff = render(chunk, nil, data, "/dev/null")
+	case err != nil:
+		change.Diff = fmt.Sprintf("Cannot compare %s: %v\n", chunk.path, err)
+	case bytes.Equal(onDisk, data):
+		change.Kind = ChangeUnchanged
+	default:
+		change.Diff = render(chunk, onDisk, data, "a/"+chunk.path)
+	}
+
+	return change
+}
+
+// render returns the diff from old, labelled oldName, to the entry content data.
+// Directories have no diff, and content that is not text is only reported as different.
+func render(chunk fileChunk, old, data []byte, oldName string) string {
+	newName := "b/" + chunk.path
+
+	switch {
+	case chunk.attrs["type"] == typeDir:
+		return ""
+	case !isDiffable(old) || !isDiffable(data):
+		return fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+	default:
+		return diff.Unified(oldName, newName, old, data)
+	}
+}
+
+// isDiffable reports whether data is text that can be shown line by line.
+func isDiffable(data []byte) bool {
+	return utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
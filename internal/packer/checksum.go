@@ -0,0 +1,42 @@
+package packer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// checksum returns the hex-encoded SHA-256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// manifest accumulates the archive digest.
+//
+// The digest is the SHA-256 over one "<sha256>  <path>\n" line per entry, in archive order,
+// which is the output format of sha256sum. It detects added, removed, reordered or modified entries
+// independently of how the archive text itself is laid out.
+type manifest struct {
+	hash  hash.Hash
+	count int
+}
+
+// newManifest creates an empty manifest.
+func newManifest() *manifest {
+	return &manifest{hash: sha256.New()}
+}
+
+// add records the checksum of one entry.
+func (m *manifest) add(path, sum string) {
+	fmt.Fprintf(m.hash, "%s  %s\n", sum, path)
+
+	m.count++
+}
+
+// digest returns the hex-encoded archive digest over all entries added so far.
+func (m *manifest) digest() string {
+	return hex.EncodeToString(m.hash.Sum(nil))
+}
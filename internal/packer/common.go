@@ -1,7 +1,9 @@
 package packer
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/idelchi/aggr/internal/config"
@@ -28,20 +30,79 @@ func Logger(dry bool) (*logger.Logger, error) {
 	return log, nil
 }
 
+// reader returns an aggregator for reading archives: it uses the configured format, or detects it if none
+// is set, and the configured marker for archives without a format header, which do not record their marker.
+func (p Packer) reader(log *logger.Logger) (*Aggregator, error) {
+	reader := NewAggregator(log, p.Options.Dry, p.Options.Parallel, p.Options.Rules.Root)
+
+	marker, err := ResolveMarker(p.Options.Marker)
+	if err != nil {
+		return nil, err
+	}
+
+	reader.Prefixes = NewPrefixes(marker)
+
+	if p.Options.Format != "" {
+		if reader.Format, err = ParseFormat(p.Options.Format); err != nil {
+			return nil, err
+		}
+	}
+
+	return reader, nil
+}
+
 // GetOutputWriter returns an output writer based on the provided options.
-// If output is set to stdout, it returns os.Stdout, otherwise it creates a new file.
-func GetOutputWriter(options config.Options) (*os.File, error) {
+// If output is set to stdout, it writes to os.Stdout, otherwise it creates a new file.
+// The output is gzip-compressed if compression is requested or the output name ends in ".gz".
+// Closing the writer flushes the compression and closes the file, but never closes os.Stdout.
+func GetOutputWriter(options config.Options) (io.WriteCloser, error) {
+	var writer outputWriter
+
 	if options.IsStdout() {
-		return os.Stdout, nil
+		writer.Writer = os.Stdout
+	} else {
+		file := file.New(options.Output)
+
+		if err := file.Create(); err != nil {
+			return nil, fmt.Errorf("creating output file %s: %w", options.Output, err)
+		}
+
+		openFile, err := file.OpenForWriting()
+		if err != nil {
+			return nil, fmt.Errorf("opening output file %s: %w", options.Output, err)
+		}
+
+		writer.Writer = openFile
+		writer.closers = []io.Closer{openFile}
+	}
+
+	if options.Compress || IsCompressed(options.Output) {
+		compressor := gzip.NewWriter(writer.Writer)
+
+		writer.Writer = compressor
+		writer.closers = append([]io.Closer{compressor}, writer.closers...)
+	}
+
+	return &writer, nil
+}
+
+// StdinArchive is the archive argument that reads the archive from standard input.
+const StdinArchive = "-"
+
+// OpenArchive opens the archive at path for reading, or standard input if path is StdinArchive.
+// Closing the returned reader never closes os.Stdin.
+func OpenArchive(path string) (io.ReadCloser, error) {
+	if path == StdinArchive {
+		return io.NopCloser(os.Stdin), nil
 	}
 
-	file := file.New(options.Output)
+	archive := file.New(path)
 
-	if err := file.Create(); err != nil {
-		return nil, fmt.Errorf("creating output file %s: %w", options.Output, err)
+	if !archive.Exists() {
+		return nil, fmt.Errorf("archive %q does not exist", archive)
 	}
 
-	return file.OpenForWriting()
+	return archive.Open()
 }
 
 // DefaultAggrignores searches for and returns the default .aggrignore file.
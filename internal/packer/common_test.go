@@ -0,0 +1,74 @@
+package packer
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/idelchi/godyl/pkg/path/file"
+	"github.com/idelchi/godyl/pkg/path/files"
+)
+
+// newTestAggregator returns an aggregator with the default settings and a single worker,
+// rooted in a temporary directory.
+func newTestAggregator(t *testing.T) *Aggregator {
+	t.Helper()
+
+	log, err := Logger(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewAggregator(log, false, 1, t.TempDir())
+}
+
+// readEntries parses stream with a and returns the content of its regular files, keyed by path.
+func readEntries(t *testing.T, a *Aggregator, stream string) map[string]string {
+	t.Helper()
+
+	entries, err := a.ReadEntries(context.Background(), strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("ReadEntries() error = %v", err)
+	}
+
+	contents := make(map[string]string, len(entries))
+	for path, data := range entries {
+		contents[path] = string(data)
+	}
+
+	return contents
+}
+
+// packContents writes contents to files under the root of a, keyed by slash-separated path,
+// and returns them packed by a, in path order.
+func packContents(t *testing.T, a *Aggregator, contents map[string]string) string {
+	t.Helper()
+
+	var set files.Files
+
+	for _, path := range slices.Sorted(maps.Keys(contents)) {
+		native := filepath.Join(a.Root, filepath.FromSlash(path))
+
+		if err := os.MkdirAll(filepath.Dir(native), dirPerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(native, []byte(contents[path]), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		set.AddFile(file.New(path))
+	}
+
+	var stream strings.Builder
+
+	if err := a.Pack(set, &stream); err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+
+	return stream.String()
+}
@@ -0,0 +1,66 @@
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Magic bytes identifying compressed archives.
+//
+//nolint:gochecknoglobals 	// Fair use of global variables.
+var (
+	// gzipMagic starts every gzip stream.
+	gzipMagic = []byte{0x1f, 0x8b}
+	// zstdMagic starts every zstd frame.
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressedExtension is the file extension that selects gzip compression for the output.
+const compressedExtension = ".gz"
+
+// IsCompressed reports whether an output with the given name should be gzip-compressed.
+func IsCompressed(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), compressedExtension)
+}
+
+// outputWriter writes to the output destination, optionally through a compressor.
+type outputWriter struct {
+	io.Writer
+
+	// closers are closed in order by Close, flushing the compressor before closing the destination.
+	closers []io.Closer
+}
+
+// Close flushes and closes the compressor, if any, and then the destination.
+// It is safe to call Close more than once.
+func (w *outputWriter) Close() error {
+	var errs []error
+
+	for _, closer := range w.closers {
+		errs = append(errs, closer.Close())
+	}
+
+	w.closers = nil
+
+	return errors.Join(errs...)
+}
+
+// decompress returns a reader yielding the uncompressed content of reader.
+// Gzip-compressed input is detected by its magic bytes and decompressed transparently,
+// other input is returned as is.
+func decompress(reader *bufio.Reader) (io.Reader, error) {
+	magic, _ := reader.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(reader)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, errors.New("zstd-compressed archives are not supported: decompress with 'zstd -d' or use gzip")
+	default:
+		return reader, nil
+	}
+}
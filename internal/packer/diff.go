@@ -0,0 +1,123 @@
+package packer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/idelchi/aggr/internal/config"
+	"github.com/idelchi/godyl/pkg/logger"
+	"github.com/idelchi/godyl/pkg/path/files"
+)
+
+// Diff compares packed files, such as the parts of a split archive, read from standard input if the path
+// is "-", with the configured root directory. It prints a unified diff for every new or modified entry,
+// followed by a summary that also lists unchanged entries and missing files: files the archive lacks that
+// are in its recorded scope, see inScope.
+// It returns an error if there are any differences.
+//
+//nolint:forbidigo	// Function prints out to the console.
+func (p Packer) Diff(ctx context.Context, packs []string) error {
+	log, err := Logger(p.Options.Dry)
+	if err != nil {
+		return err
+	}
+
+	differ, err := p.reader(log)
+	if err != nil {
+		return err
+	}
+
+	var (
+		changes []Change
+		scope   *Scope
+	)
+
+	packed := map[string]bool{}
+
+	for _, path := range packs {
+		reader, err := OpenArchive(path)
+		if err != nil {
+			return err
+		}
+
+		compared, recorded, err := differ.Diff(ctx, reader, p.Options.Rules.Root)
+		reader.Close()
+
+		if err != nil {
+			return fmt.Errorf("comparing %q: %w", path, err)
+		}
+
+		if scope == nil {
+			scope = recorded
+		}
+
+		for _, change := range compared {
+			packed[change.Path] = true
+		}
+
+		changes = append(changes, compared...)
+	}
+
+	// The archives themselves are not missing from the archive.
+	collected, err := p.inScope(log, scope, packs...)
+	if err != nil {
+		return err
+	}
+
+	for _, collectedFile := range collected {
+		if !packed[collectedFile.Path()] {
+			changes = append(changes, Change{Path: collectedFile.Path(), Kind: ChangeMissing})
+		}
+	}
+
+	counts := map[ChangeKind]int{}
+
+	for _, change := range changes {
+		counts[change.Kind]++
+
+		fmt.Print(change.Diff)
+	}
+
+	slices.SortStableFunc(changes, func(a, b Change) int {
+		return strings.Compare(strings.ToLower(a.Path), strings.ToLower(b.Path))
+	})
+
+	log.Infof("Comparing with %q:", p.Options.Rules.Root)
+
+	for _, change := range changes {
+		if change.Kind == ChangeUnchanged {
+			log.Debugf("- %s: %s", change.Kind, change.Path)
+		} else {
+			log.Infof("- %s: %s", change.Kind, change.Path)
+		}
+	}
+
+	log.Infof("%d new, %d modified, %d unchanged, %d missing",
+		counts[ChangeNew], counts[ChangeModified], counts[ChangeUnchanged], counts[ChangeMissing])
+
+	if differences := len(changes) - counts[ChangeUnchanged]; differences > 0 {
+		return fmt.Errorf("found %d difference(s) with %q", differences, p.Options.Rules.Root)
+	}
+
+	return nil
+}
+
+// inScope returns the files under the root directory that an archive with the given recorded scope covers,
+// sorted by path. For archives that do not record their scope, it uses the scope of packing the root directory
+// with the current filtering rules. Paths matching excludes are left out. Unlike packing, the number of files
+// is not limited, as nothing is packed.
+func (p Packer) inScope(log *logger.Logger, scope *Scope, excludes ...string) (files.Files, error) {
+	if scope == nil {
+		current, err := p.scope(log, []string{config.DefaultPattern}, excludes...)
+		if err != nil {
+			return nil, err
+		}
+
+		return walk(log, p.Options.Rules.Root, current.Patterns, current.checkers(), math.MaxInt)
+	}
+
+	return walk(log, p.Options.Rules.Root, scope.Patterns, scope.checkers(excludes...), math.MaxInt)
+}
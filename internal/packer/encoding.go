@@ -0,0 +1,121 @@
+package packer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+// Content encodings recorded in the "encoding" attribute.
+const (
+	// encodingBase64 marks binary content stored as line-wrapped standard base64.
+	encodingBase64 = "base64"
+)
+
+// Line endings recorded in the "eol" attribute. Files using LF line endings carry no eol attribute.
+const (
+	// eolCRLF marks a file whose line breaks are all CRLF, stored with LF line breaks.
+	eolCRLF = "crlf"
+)
+
+// isCRLF reports whether data contains line breaks and all of them are CRLF.
+func isCRLF(data []byte) bool {
+	breaks := bytes.Count(data, []byte("\n"))
+
+	return breaks > 0 && bytes.Count(data, []byte("\r\n")) == breaks
+}
+
+// base64LineLength is the number of encoded characters per line, as in MIME.
+const base64LineLength = 76
+
+// encodeBase64 encodes data as standard base64, wrapped at base64LineLength characters,
+// with every line terminated by a newline.
+func encodeBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var buf bytes.Buffer
+
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength])
+		buf.WriteByte('\n')
+
+		encoded = encoded[base64LineLength:]
+	}
+
+	buf.WriteString(encoded)
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+// decodeBase64 decodes line-wrapped standard base64, ignoring all line breaks and surrounding whitespace.
+func decodeBase64(body []byte) ([]byte, error) {
+	encoded := bytes.Join(bytes.Fields(body), nil)
+
+	data, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decoding base64: %w", err)
+	}
+
+	return data, nil
+}
+
+// normalizeEOL converts CRLF line endings to LF and records the change in attrs.
+// Files with mixed line endings are kept as is, so the archive stays uniform where possible.
+func normalizeEOL(data []byte, attrs Attributes) []byte {
+	if !isCRLF(data) {
+		return data
+	}
+
+	attrs["eol"] = eolCRLF
+
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
+
+// restoreEOL undoes normalizeEOL by reapplying the line endings recorded in attrs.
+func restoreEOL(data []byte, attrs Attributes) ([]byte, error) {
+	switch eol := attrs["eol"]; eol {
+	case "":
+		return data, nil
+	case eolCRLF:
+		return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")), nil
+	default:
+		return nil, fmt.Errorf("unsupported line ending %q", eol)
+	}
+}
+
+// normalizeText prepares text content for a line-oriented archive: line endings are normalized
+// and the trailing newlines are replaced by exactly one, with the changes recorded in attrs.
+func normalizeText(data []byte, attrs Attributes) []byte {
+	body, newlines := trimNewlines(normalizeEOL(data, attrs))
+
+	if newlines != 1 {
+		attrs["newlines"] = strconv.Itoa(newlines)
+	}
+
+	return append(body, '\n')
+}
+
+// restoreText undoes normalizeText by reapplying the trailing newlines and line endings recorded in attrs.
+func restoreText(body []byte, attrs Attributes) ([]byte, error) {
+	newlines, err := attrs.Int("newlines", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if newlines < 0 {
+		return nil, fmt.Errorf("negative trailing newline count %d", newlines)
+	}
+
+	body, _ = trimNewlines(body)
+
+	return restoreEOL(append(body, bytes.Repeat([]byte("\n"), newlines)...), attrs)
+}
+
+// trimNewlines strips all trailing newlines from data and reports how many were removed.
+func trimNewlines(data []byte) ([]byte, int) {
+	body := bytes.TrimRight(data, "\n")
+
+	return body, len(data) - len(body)
+}
@@ -0,0 +1,171 @@
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Format identifies the layout of an archive.
+type Format string
+
+const (
+	// FormatAggr is the plain text format, delimiting files with BEGIN and END markers.
+	FormatAggr Format = "aggr"
+	// FormatMarkdown renders every file as a heading followed by a fenced code block.
+	FormatMarkdown Format = "markdown"
+	// FormatXML wraps every file in a document element, as recommended for prompts by several model vendors.
+	FormatXML Format = "xml"
+	// FormatJSON writes a single JSON document holding all entries.
+	FormatJSON Format = "json"
+	// FormatJSONL writes one JSON object per line and entry.
+	FormatJSONL Format = "jsonl"
+)
+
+// Formats lists the supported archive formats.
+//
+//nolint:gochecknoglobals	// Read-only list of formats.
+var Formats = []Format{FormatAggr, FormatMarkdown, FormatXML, FormatJSON, FormatJSONL}
+
+// ParseFormat returns the format with the given name. An empty name selects FormatAggr.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", string(FormatAggr):
+		return FormatAggr, nil
+	case string(FormatMarkdown), "md":
+		return FormatMarkdown, nil
+	case string(FormatXML):
+		return FormatXML, nil
+	case string(FormatJSON):
+		return FormatJSON, nil
+	case string(FormatJSONL), "ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown format %q: must be one of %v", name, Formats)
+	}
+}
+
+// Extension returns the file extension used for archives in the format.
+func (f Format) Extension() string {
+	switch f {
+	case FormatMarkdown:
+		return ".md"
+	case FormatXML:
+		return ".xml"
+	case FormatJSON:
+		return ".json"
+	case FormatJSONL:
+		return ".jsonl"
+	default:
+		return ".aggr"
+	}
+}
+
+// entry is a packed entry before it is rendered in an archive format.
+type entry struct {
+	path  string
+	attrs Attributes
+	// data is the content of a regular file, base64-encoded if attrs record an encoding.
+	data []byte
+}
+
+// codec renders entries in, and parses them from, one archive format.
+type codec interface {
+	// header returns the text written before the first entry.
+	header() string
+	// entry returns the rendered entry.
+	entry(e entry) []byte
+	// footer returns the text written after the last entry, summarizing the packed entries.
+	footer(r report) string
+	// parse reads entries from reader and sends them to chunks, with text content fully restored.
+	// It returns the summary found in the footer, if any.
+	parse(ctx context.Context, reader *bufio.Reader, chunks chan<- fileChunk) (footer, error)
+}
+
+// separated is implemented by codecs that write a separator between entries.
+type separated interface {
+	// separator returns the text written between two entries.
+	separator() string
+}
+
+// separatorOf returns the text codec writes between entries, if any.
+func separatorOf(codec codec) string {
+	if withSeparator, ok := codec.(separated); ok {
+		return withSeparator.separator()
+	}
+
+	return ""
+}
+
+// codec returns the codec for format.
+func (a *Aggregator) codec(format Format) codec {
+	switch format {
+	case FormatMarkdown:
+		return markdownCodec{a: a}
+	case FormatXML:
+		return xmlCodec{a: a}
+	case FormatJSON:
+		return jsonCodec{a: a}
+	case FormatJSONL:
+		return jsonCodec{a: a, lines: true}
+	default:
+		return aggrCodec{a: a}
+	}
+}
+
+// sniffLength is the number of bytes inspected to detect the format of a stream.
+const sniffLength = 512
+
+// detectFormat guesses the format of a stream from its first non-blank line, without consuming it.
+// If that line is not recognized, as in responses that start with prose, the start of the stream is
+// searched for XML documents, and for the first marker line or Markdown heading instead. Headings after
+// a marker line belong to a packed file, such as a README, so they do not make the stream Markdown.
+// Streams that are not recognized are assumed to be in FormatAggr, as written by older versions.
+func detectFormat(reader *bufio.Reader) Format {
+	start, _ := reader.Peek(sniffLength)
+	line, _, _ := bytes.Cut(bytes.TrimLeft(start, " \t\r\n"), []byte("\n"))
+
+	switch text := string(line); {
+	case strings.HasPrefix(text, headerPrefix+" ["), strings.Contains(text, " BEGIN: "):
+		return FormatAggr
+	case strings.HasPrefix(text, commentPrefix), strings.HasPrefix(text, headingPrefix):
+		return FormatMarkdown
+	case strings.HasPrefix(text, "<"+xmlDocument), strings.HasPrefix(text, "<?xml"):
+		return FormatXML
+	case strings.HasPrefix(text, "{"):
+		// Both JSON formats share a parser.
+		return FormatJSON
+	case bytes.Contains(start, []byte("<"+xmlDocument+">")), bytes.Contains(start, []byte("<"+xmlDocument+" ")):
+		return FormatXML
+	}
+
+	heading := bytes.Index(start, []byte("\n"+headingPrefix))
+	if marker := markerLine(start); heading >= 0 && (marker < 0 || heading < marker) {
+		return FormatMarkdown
+	}
+
+	return FormatAggr
+}
+
+// markerLine returns the offset of the first line in start that is a format header or a BEGIN marker line
+// of FormatAggr, or -1 if there is none.
+func markerLine(start []byte) int {
+	offset := 0
+
+	for line := range bytes.Lines(start) {
+		if text := string(line); strings.HasPrefix(text, headerPrefix+" [") || strings.Contains(text, " BEGIN: ") {
+			return offset
+		}
+
+		offset += len(line)
+	}
+
+	return -1
+}
+
+// isText reports whether attrs describe a regular file stored as text.
+func isText(attrs Attributes) bool {
+	return attrs["type"] == "" && attrs["encoding"] == ""
+}
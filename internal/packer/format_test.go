@@ -0,0 +1,74 @@
+package packer
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		stream string
+		want   Format
+	}{
+		{
+			name:   "format header",
+			stream: "aggr [format=1 marker=\"// === AGGR:\"]\n// === AGGR: BEGIN: main.go\n",
+			want:   FormatAggr,
+		},
+		{
+			name:   "markdown header",
+			stream: "<!-- aggr [format=1] -->\n\n## `main.go`\n",
+			want:   FormatMarkdown,
+		},
+		{
+			name:   "xml",
+			stream: "<documents format=\"1\">\n<document>\n",
+			want:   FormatXML,
+		},
+		{
+			name:   "json",
+			stream: "{\"format\":1}\n",
+			want:   FormatJSON,
+		},
+		{
+			name:   "markdown after prose",
+			stream: "Here are the files:\n\n## `main.go`\n\n```go\npackage main\n```\n",
+			want:   FormatMarkdown,
+		},
+		{
+			name:   "xml after prose",
+			stream: "Here are the files:\n\n<document>\n<source>main.go</source>\n",
+			want:   FormatXML,
+		},
+		{
+			name: "aggr after prose with a markdown file",
+			stream: "Here are the files:\n\n// === AGGR: BEGIN: README.md\n# Title\n\n## Usage\n\n" +
+				"// === AGGR: END: README.md\n",
+			want: FormatAggr,
+		},
+		{
+			name:   "aggr after prose with a header",
+			stream: "Sure:\n\naggr [format=1 marker=\"# === AGGR:\"]\n# === AGGR: BEGIN: README.md\n## Usage\n",
+			want:   FormatAggr,
+		},
+		{
+			name:   "unrecognized",
+			stream: "nothing to see here\n",
+			want:   FormatAggr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := detectFormat(bufio.NewReader(strings.NewReader(test.stream))); got != test.want {
+				t.Errorf("detectFormat() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
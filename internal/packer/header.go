@@ -0,0 +1,83 @@
+package packer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion is the version of the archive format written by this version of aggr.
+// Readers accept all versions up to and including it.
+const FormatVersion = 1
+
+// headerPrefix starts the format header line, which is followed by the format attributes:
+//
+//	aggr [format=1 marker="// === AGGR:"]
+const headerPrefix = "aggr"
+
+// header returns the format header line naming the format version and the marker.
+func (p Prefixes) header() string {
+	attrs := Attributes{
+		"format": strconv.Itoa(FormatVersion),
+		"marker": p.Marker,
+	}
+
+	return headerPrefix + attrs.String() + "\n"
+}
+
+// parseHeader parses a format header line and returns the prefixes for the marker it names.
+func parseHeader(line string) (Prefixes, error) {
+	attrs, err := parseAttributes(strings.TrimSpace(strings.TrimPrefix(line, headerPrefix)))
+	if err != nil {
+		return Prefixes{}, fmt.Errorf("invalid format header: %w", err)
+	}
+
+	if err := checkVersion(attrs, line); err != nil {
+		return Prefixes{}, err
+	}
+
+	marker := attrs["marker"]
+	if marker == "" {
+		return Prefixes{}, fmt.Errorf("invalid format header: missing marker in %q", line)
+	}
+
+	return NewPrefixes(marker), nil
+}
+
+// checkVersion validates the format version recorded in the header attributes of line.
+func checkVersion(attrs Attributes, line string) error {
+	version, err := attrs.Int("format", 0)
+	if err != nil || version < 1 {
+		return fmt.Errorf("invalid format header: missing or invalid format version in %q", line)
+	}
+
+	if version > FormatVersion {
+		return fmt.Errorf(
+			"archive format version %d is not supported, "+
+				"this version of aggr reads up to version %d: please upgrade aggr",
+			version,
+			FormatVersion,
+		)
+	}
+
+	return nil
+}
+
+// readHeader consumes the format header at the start of the stream, if present,
+// and returns the prefixes for the marker it names. Header-less archives use the configured prefixes.
+// The prefixes only apply to the stream being read, so they are not stored in the aggregator.
+func (a *Aggregator) readHeader(reader *bufio.Reader) (Prefixes, error) {
+	start, _ := reader.Peek(len(headerPrefix + " ["))
+	if string(start) != headerPrefix+" [" {
+		return a.Prefixes, nil
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return Prefixes{}, err
+	}
+
+	return parseHeader(line)
+}
@@ -0,0 +1,343 @@
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/idelchi/aggr/internal/tree"
+)
+
+// Keys of the objects in JSON archives that are not entry fields.
+const (
+	jsonFormat  = "format"
+	jsonEntries = "entries"
+	jsonSummary = "summary"
+	jsonPath    = "path"
+)
+
+// jsonEntry is an entry in a JSON archive.
+type jsonEntry struct {
+	// Path is the path of the entry.
+	Path string `json:"path"`
+	// Size is the size of the original content in bytes.
+	Size int `json:"size"`
+	// Lines is the number of lines of a text file.
+	Lines int `json:"lines,omitempty"`
+	// Content is the content of a file, base64-encoded if Attributes record an encoding.
+	Content *string `json:"content,omitempty"`
+	// Attributes holds the same attributes as the other formats.
+	Attributes Attributes `json:"attributes,omitempty"`
+}
+
+// jsonSummaryObject replaces the footer in JSON archives.
+type jsonSummaryObject struct {
+	// Files is the number of packed files.
+	Files int `json:"files"`
+	// Tokens is the estimated number of tokens of all entries.
+	Tokens int `json:"tokens"`
+	// SHA256 is the archive digest.
+	SHA256 string `json:"sha256,omitempty"`
+	// Scope is the scope of the archive.
+	Scope *Scope `json:"scope,omitempty"`
+	// Part records which part of a split archive the archive is.
+	Part *Part `json:"part,omitempty"`
+	// Tree is the structured tree of packed files.
+	Tree []*tree.Node `json:"tree"`
+}
+
+// jsonCodec implements FormatJSON and, if lines is true, FormatJSONL.
+//
+// FormatJSON writes a single document holding the format version, the entries and the summary.
+// FormatJSONL writes one object per line: the format version, one object per entry and the summary.
+// Parsing accepts both, including documents on a single line.
+//
+// JSON represents any text exactly, so content is stored as is. Only content that is not valid UTF-8
+// is stored as base64, as JSON strings cannot hold it.
+type jsonCodec struct {
+	a     *Aggregator
+	lines bool
+}
+
+// header returns the opening of the document, or the line naming the format version.
+func (c jsonCodec) header() string {
+	if c.lines {
+		return fmt.Sprintf("{%q:%d}\n", jsonFormat, FormatVersion)
+	}
+
+	return fmt.Sprintf("{\n  %q: %d,\n  %q: [\n", jsonFormat, FormatVersion, jsonEntries)
+}
+
+// separator returns the text written between two entries.
+func (c jsonCodec) separator() string {
+	if c.lines {
+		return ""
+	}
+
+	return ",\n"
+}
+
+// entry renders e as a JSON object.
+func (c jsonCodec) entry(e entry) []byte {
+	object := jsonEntry{Path: e.path, Attributes: e.attrs}
+
+	switch {
+	case e.attrs["type"] == typeSymlink:
+		object.Size = len(e.attrs["target"])
+	case e.attrs["type"] != "":
+	case e.attrs["encoding"] != "":
+		data, _ := decodeBase64(e.data)
+		content := string(bytes.Join(bytes.Fields(e.data), nil))
+
+		object.Size, object.Content = len(data), &content
+	case !utf8.Valid(e.data):
+		e.attrs["encoding"] = encodingBase64
+		content := base64.StdEncoding.EncodeToString(e.data)
+
+		object.Size, object.Content = len(e.data), &content
+	default:
+		content := string(e.data)
+
+		object.Size, object.Lines, object.Content = len(e.data), countLines(e.data), &content
+	}
+
+	return c.marshal(object)
+}
+
+// footer returns the summary and, outside of dry run mode, closes the document.
+// In dry run mode, the summary is written as a document of its own.
+func (c jsonCodec) footer(r report) string {
+	summary := jsonSummaryObject{
+		Files:  len(r.set),
+		Tokens: r.total(),
+		SHA256: r.digest,
+		Scope:  r.scope,
+		Part:   r.part,
+		Tree:   tree.Nodes(r.set, c.a.fill(r)),
+	}
+
+	if c.lines {
+		return string(c.marshal(map[string]jsonSummaryObject{jsonSummary: summary}))
+	}
+
+	data := marshalJSON(summary, "  ")
+
+	opening := "\n  ],\n"
+	if c.a.Dry {
+		opening = "{\n"
+	}
+
+	return fmt.Sprintf("%s  %q: %s\n}\n", opening, jsonSummary, data)
+}
+
+// marshal renders value on a single line in FormatJSONL, or indented as an element of the entries array.
+func (c jsonCodec) marshal(value any) []byte {
+	if c.lines {
+		return append(marshalJSON(value, ""), '\n')
+	}
+
+	return append([]byte("    "), marshalJSON(value, "    ")...)
+}
+
+// marshalJSON encodes value without escaping HTML characters, so content stays readable.
+// Unless prefix is empty, the output is indented, with every line but the first starting with prefix.
+func marshalJSON(value any, prefix string) []byte {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if prefix != "" {
+		encoder.SetIndent(prefix, "  ")
+	}
+
+	_ = encoder.Encode(value)
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// parse reads an archive in FormatJSON or FormatJSONL, which may contain any number of top-level objects.
+// The entries array of a document is streamed, so large archives are not held in memory.
+//
+//nolint:gocognit	// Function is complex by design.
+func (c jsonCodec) parse(ctx context.Context, reader *bufio.Reader, chunks chan<- fileChunk) (footer, error) {
+	var summary footer
+
+	decoder := json.NewDecoder(reader)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return summary, ctx.Err()
+		default:
+		}
+
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+
+		if err != nil {
+			return summary, err
+		}
+
+		if token != json.Delim('{') {
+			return summary, fmt.Errorf("unexpected %v: expected an object", token)
+		}
+
+		record := map[string]json.RawMessage{}
+
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return summary, err
+			}
+
+			key, _ := token.(string)
+
+			if key == jsonEntries {
+				if err := parseJSONEntries(ctx, decoder, chunks); err != nil {
+					return summary, err
+				}
+
+				continue
+			}
+
+			var value json.RawMessage
+
+			if err := decoder.Decode(&value); err != nil {
+				return summary, err
+			}
+
+			record[key] = value
+		}
+
+		if _, err := decoder.Token(); err != nil {
+			return summary, err
+		}
+
+		if err := parseJSONRecord(record, &summary, chunks); err != nil {
+			return summary, err
+		}
+	}
+}
+
+// parseJSONEntries streams the entries array of a document to chunks.
+func parseJSONEntries(ctx context.Context, decoder *json.Decoder, chunks chan<- fileChunk) error {
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return fmt.Errorf("%q must be an array", jsonEntries)
+	}
+
+	for decoder.More() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		var object jsonEntry
+
+		if err := decoder.Decode(&object); err != nil {
+			return err
+		}
+
+		chunk, err := object.chunk()
+		if err != nil {
+			return err
+		}
+
+		chunks <- chunk
+	}
+
+	_, err := decoder.Token()
+
+	return err
+}
+
+// parseJSONRecord handles a top-level object other than the entries array: the format version,
+// an entry on its own line or the summary.
+func parseJSONRecord(record map[string]json.RawMessage, summary *footer, chunks chan<- fileChunk) error {
+	if raw, ok := record[jsonFormat]; ok {
+		var version int
+
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return fmt.Errorf("invalid format version %s", raw)
+		}
+
+		if err := checkVersion(Attributes{"format": strconv.Itoa(version)}, string(raw)); err != nil {
+			return err
+		}
+	}
+
+	if raw, ok := record[jsonSummary]; ok {
+		var object jsonSummaryObject
+
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return fmt.Errorf("invalid summary: %w", err)
+		}
+
+		summary.found, summary.files, summary.digest = true, object.Files, object.SHA256
+		summary.scope, summary.part = object.Scope, object.Part
+	}
+
+	if _, ok := record[jsonPath]; !ok {
+		return nil
+	}
+
+	raw, _ := json.Marshal(record)
+
+	var object jsonEntry
+
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return fmt.Errorf("invalid entry: %w", err)
+	}
+
+	chunk, err := object.chunk()
+	if err != nil {
+		return err
+	}
+
+	chunks <- chunk
+
+	return nil
+}
+
+// chunk converts a parsed entry into a file chunk.
+func (e jsonEntry) chunk() (fileChunk, error) {
+	attrs := e.Attributes
+	if attrs == nil {
+		attrs = Attributes{}
+	}
+
+	chunk := fileChunk{path: e.Path, attrs: attrs}
+
+	if attrs["type"] != "" {
+		return chunk, nil
+	}
+
+	if e.Content == nil {
+		return fileChunk{}, fmt.Errorf("%s: missing content", e.Path)
+	}
+
+	chunk.data = []byte(*e.Content)
+
+	return chunk, nil
+}
+
+// countLines returns the number of lines in data, counting a final line without a newline.
+func countLines(data []byte) int {
+	lines := bytes.Count(data, []byte("\n"))
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+
+	return lines
+}
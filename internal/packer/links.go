@@ -0,0 +1,168 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxLinks bounds the number of symbolic links followed to resolve a link target, as operating systems do.
+const maxLinks = 40
+
+// writeLinks validates and writes the symbolic link entries queued by writeChunk, in path order, once all other
+// entries are written. Unlike validateSymlink, it resolves each target through the links it passes, such as the
+// chain of l -> . and e -> l/.., which escapes although each target looks local on its own. Refusing a link changes
+// how the others resolve, so the remaining links are validated again until none is refused.
+func (a *Aggregator) writeLinks(dst string, sink *filesSink) error {
+	slices.SortFunc(sink.links, func(x, y fileChunk) int { return strings.Compare(x.path, y.path) })
+
+	links := make(map[string]string, len(sink.links))
+
+	for _, chunk := range sink.links {
+		links[linkPath(chunk.path)] = chunk.attrs["target"]
+	}
+
+	for refused := true; refused; {
+		refused = false
+
+		for _, chunk := range sink.links {
+			path := linkPath(chunk.path)
+
+			if _, ok := links[path]; !ok {
+				continue
+			}
+
+			if err := a.validateLink(dst, links, path); err != nil {
+				delete(links, path)
+				a.reject(sink, chunk.path, err)
+
+				refused = true
+			}
+		}
+	}
+
+	for _, chunk := range sink.links {
+		if _, ok := links[linkPath(chunk.path)]; !ok {
+			continue
+		}
+
+		if err := a.writeEntry(chunk, []byte(chunk.attrs["target"]), dst, sink); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateLink refuses the queued link at path if another queued link is one of its parents, if a directory
+// was unpacked at its path, or if its target escapes the destination, see resolveLink.
+func (a *Aggregator) validateLink(dst string, links map[string]string, path string) error {
+	for parent := filepath.Dir(path); parent != "."; parent = filepath.Dir(parent) {
+		if _, ok := links[parent]; ok {
+			return fmt.Errorf("parent directory %q is a symbolic link", filepath.ToSlash(parent))
+		}
+	}
+
+	if a.Staging != "" {
+		if info, err := os.Lstat(filepath.Join(a.Staging, path)); err == nil && info.IsDir() {
+			return errors.New("a directory was unpacked at the same path")
+		}
+	}
+
+	return a.resolveLink(dst, links, path)
+}
+
+// resolveLink resolves the target of the queued link at path component by component, relative to the
+// destination, following every link it passes, see linkAt. It refuses targets that leave the destination
+// at any point, and targets that pass too many links to resolve.
+func (a *Aggregator) resolveLink(dst string, links map[string]string, path string) error {
+	var resolved []string
+
+	if dir := filepath.Dir(path); dir != "." {
+		resolved = strings.Split(dir, string(filepath.Separator))
+	}
+
+	pending := splitLink(links[path])
+	followed := 0
+
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return fmt.Errorf("symbolic link target %q escapes the destination", links[path])
+			}
+
+			resolved = resolved[:len(resolved)-1]
+
+			continue
+		}
+
+		resolved = append(resolved, part)
+
+		target, ok := a.linkAt(dst, links, filepath.Join(resolved...))
+		if !ok {
+			continue
+		}
+
+		if followed++; followed > maxLinks {
+			return fmt.Errorf("symbolic link target %q passes too many symbolic links", links[path])
+		}
+
+		if filepath.IsAbs(target) {
+			return fmt.Errorf("symbolic link target %q escapes the destination", links[path])
+		}
+
+		resolved = resolved[:len(resolved)-1]
+		pending = append(splitLink(target), pending...)
+	}
+
+	return nil
+}
+
+// linkAt returns the target of the link at path, relative to the destination. Queued links take precedence
+// over the entries in the staging directory, which take precedence over the entries in dst.
+// It reports false if the entry at path is not a link.
+func (a *Aggregator) linkAt(dst string, links map[string]string, path string) (string, bool) {
+	if target, ok := links[path]; ok {
+		return target, true
+	}
+
+	for _, root := range []string{a.Staging, dst} {
+		if root == "" {
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(root, path))
+		if err != nil {
+			continue
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			return "", false
+		}
+
+		target, err := os.Readlink(filepath.Join(root, path))
+
+		return target, err == nil
+	}
+
+	return "", false
+}
+
+// linkPath returns the native, clean form of the entry path of a link, used as key of the queued links.
+func linkPath(path string) string {
+	return filepath.Clean(filepath.FromSlash(path))
+}
+
+// splitLink splits a link target into its components, without cleaning it: "l/.." must resolve l first.
+func splitLink(target string) []string {
+	return strings.Split(filepath.FromSlash(target), string(filepath.Separator))
+}
@@ -0,0 +1,181 @@
+package packer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// linkEntry renders a symbolic link entry at path with target, in the default format.
+func linkEntry(path, target string) string {
+	return "// === AGGR: BEGIN: " + path + " [target=" + target + " type=symlink]\n// === AGGR: END: " + path + "\n"
+}
+
+// fileEntry renders a regular file entry at path with content, in the default format.
+func fileEntry(path, content string) string {
+	return "// === AGGR: BEGIN: " + path + "\n" + content + "\n// === AGGR: END: " + path + "\n"
+}
+
+// unpack unpacks stream into dst with a and returns the outcome.
+func unpack(t *testing.T, a *Aggregator, dst, stream string) Unpacked {
+	t.Helper()
+
+	result, err := a.Unpack(context.Background(), strings.NewReader(stream), dst, nil)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+
+	return result
+}
+
+// rejected returns the sorted paths of the entries refused in result.
+func rejected(result Unpacked) []string {
+	var paths []string
+
+	for _, problem := range result.Rejected {
+		paths = append(paths, problem.Path)
+	}
+
+	slices.Sort(paths)
+
+	return paths
+}
+
+func TestUnpackSymlinkChains(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		existing map[string]string
+		stream   string
+		rejected []string
+	}{
+		{
+			name:     "chain escaping through a later link",
+			stream:   linkEntry("e", "l/..") + linkEntry("l", "."),
+			rejected: []string{"e"},
+		},
+		{
+			name:     "chain escaping through an earlier link",
+			stream:   linkEntry("l", ".") + linkEntry("e", "l/.."),
+			rejected: []string{"e"},
+		},
+		{
+			name:     "nested chain",
+			stream:   linkEntry("l", ".") + linkEntry("d/x", "../l/l/.."),
+			rejected: []string{"d/x"},
+		},
+		{
+			name:     "chain through an existing link",
+			existing: map[string]string{"up": ".."},
+			stream:   linkEntry("x", "up/sub"),
+			rejected: []string{"x"},
+		},
+		{
+			name:     "loop, dangling once the first link is refused",
+			stream:   linkEntry("a", "b") + linkEntry("b", "a"),
+			rejected: []string{"a"},
+		},
+		{
+			name:     "below another link",
+			stream:   linkEntry("l", "d") + linkEntry("l/x", "y"),
+			rejected: []string{"l/x"},
+		},
+		{
+			name:   "local chain",
+			stream: linkEntry("a", "b/c") + linkEntry("b", "d/..") + fileEntry("d/c", "content"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := filepath.Join(t.TempDir(), "dst")
+
+			if err := os.Mkdir(dst, dirPerm); err != nil {
+				t.Fatal(err)
+			}
+
+			for path, target := range test.existing {
+				if err := os.Symlink(target, filepath.Join(dst, path)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result := unpack(t, newTestAggregator(t), dst, test.stream)
+
+			if got := rejected(result); !slices.Equal(got, test.rejected) {
+				t.Errorf("rejected %v, want %v", got, test.rejected)
+			}
+
+			for _, path := range test.rejected {
+				if _, err := os.Lstat(filepath.Join(dst, path)); err == nil {
+					t.Errorf("%s was written", path)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackStagedSymlinkParents(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		archives []string
+		rejected []string
+	}{
+		{
+			name:     "file below a chain escaping the destination",
+			archives: []string{linkEntry("l", ".") + linkEntry("e", "l/..") + fileEntry("e/pwned", "pwned")},
+			rejected: []string{"e"},
+		},
+		{
+			name:     "file below a link staged by an earlier archive",
+			archives: []string{linkEntry("l", "d"), fileEntry("l/x", "x")},
+			rejected: []string{"l/x"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "dst")
+			a := newTestAggregator(t)
+			a.Overwrite = PolicyForce
+
+			if err := a.Stage(dst); err != nil {
+				t.Fatal(err)
+			}
+
+			var result Unpacked
+
+			for _, archive := range test.archives {
+				result.Merge(unpack(t, a, dst, archive))
+			}
+
+			if err := a.Commit(dst); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+
+			if got := rejected(result); !slices.Equal(got, test.rejected) {
+				t.Errorf("rejected %v, want %v", got, test.rejected)
+			}
+
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != 1 || entries[0].Name() != "dst" {
+				t.Errorf("entries next to the destination: %v, want only dst", entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/idelchi/aggr/internal/tree"
+)
+
+// Markdown building blocks.
+const (
+	// commentPrefix starts the HTML comments holding the format header and the attributes of an entry:
+	//
+	//	<!-- aggr [format=1] -->
+	commentPrefix = "<!-- " + headerPrefix
+	// commentSuffix ends the HTML comments.
+	commentSuffix = "-->"
+	// headingPrefix starts the heading naming an entry, or the tree in the footer.
+	headingPrefix = "## "
+	// treeHeading opens the footer. Entry headings are always code spans, so they cannot collide with it.
+	treeHeading = headingPrefix + "Tree"
+	// minFenceLength is the minimum length of a code fence.
+	minFenceLength = 3
+)
+
+// languages maps file extensions and names to the language tags of fenced code blocks.
+//
+//nolint:gochecknoglobals	// Read-only lookup table.
+var languages = map[string]string{
+	".bash":       "bash",
+	".c":          "c",
+	".cc":         "cpp",
+	".cjs":        "javascript",
+	".cpp":        "cpp",
+	".cs":         "csharp",
+	".css":        "css",
+	".dart":       "dart",
+	".go":         "go",
+	".h":          "c",
+	".hcl":        "hcl",
+	".hpp":        "cpp",
+	".html":       "html",
+	".ini":        "ini",
+	".java":       "java",
+	".js":         "javascript",
+	".json":       "json",
+	".jsx":        "jsx",
+	".kt":         "kotlin",
+	".lua":        "lua",
+	".md":         "markdown",
+	".mjs":        "javascript",
+	".php":        "php",
+	".proto":      "protobuf",
+	".ps1":        "powershell",
+	".py":         "python",
+	".r":          "r",
+	".rb":         "ruby",
+	".rs":         "rust",
+	".scala":      "scala",
+	".scss":       "scss",
+	".sh":         "bash",
+	".sql":        "sql",
+	".swift":      "swift",
+	".tf":         "hcl",
+	".toml":       "toml",
+	".ts":         "typescript",
+	".tsx":        "tsx",
+	".txt":        "text",
+	".vue":        "vue",
+	".xml":        "xml",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".zsh":        "zsh",
+	"dockerfile":  "dockerfile",
+	"gnumakefile": "makefile",
+	"makefile":    "makefile",
+}
+
+// language returns the language tag for the file at path, or an empty string if it is unknown.
+func language(file string) string {
+	base := strings.ToLower(path.Base(file))
+
+	if lang, ok := languages[base]; ok {
+		return lang
+	}
+
+	return languages[path.Ext(base)]
+}
+
+// markdownCodec implements FormatMarkdown.
+//
+// Every entry is a level-two heading holding the path as a code span, followed by an HTML comment
+// with the entry attributes and, for files, a fenced code block with the content. The fence is
+// longer than any run of backticks in the content, so the content never closes it early.
+type markdownCodec struct {
+	a *Aggregator
+}
+
+// header returns the HTML comment naming the format version.
+func (c markdownCodec) header() string {
+	return comment(Attributes{"format": strconv.Itoa(FormatVersion)}) + "\n"
+}
+
+// entry renders e as a heading, an attribute comment and, for files, a fenced code block.
+func (c markdownCodec) entry(e entry) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s%s\n\n", headingPrefix, codeSpan(formatPath(e.path)))
+
+	body := e.data
+	if isText(e.attrs) {
+		body = normalizeText(e.data, e.attrs)
+	}
+
+	buf.WriteString(comment(e.attrs))
+
+	if e.attrs["type"] == "" {
+		lang := language(e.path)
+		if e.attrs["encoding"] != "" {
+			lang = ""
+		}
+
+		fence := fenceFor(body)
+
+		fmt.Fprintf(&buf, "%s%s\n", fence, lang)
+		buf.Write(body)
+		buf.WriteString(fence + "\n")
+	}
+
+	buf.WriteString("\n")
+
+	return buf.Bytes()
+}
+
+// footer returns the tree as a code block, the file count, the estimated number of tokens and,
+// if set, the scope, the part and the archive digest.
+func (c markdownCodec) footer(r report) string {
+	printed := tree.Generate(r.set, c.a.label(r)).String()
+	fence := fenceFor([]byte(printed))
+
+	return treeHeading + "\n\n" + fence + "text\n" + printed + fence + "\n\n" + r.lines()
+}
+
+// parse reads an archive in FormatMarkdown.
+// Text outside headings, attribute comments and code blocks is ignored, so archives remain readable
+// after being edited, for example by a chat tool. Entries without an attribute comment are restored
+// with default attributes.
+//
+//nolint:gocognit,funlen	// Function is complex by design.
+func (c markdownCodec) parse(ctx context.Context, reader *bufio.Reader, chunks chan<- fileChunk) (footer, error) {
+	var (
+		summary footer
+		current *entry
+		buf     bytes.Buffer
+		fence   string
+		fenced  bool
+		crlf    bool
+	)
+
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+
+		data := append([]byte(nil), buf.Bytes()...)
+
+		if isText(current.attrs) {
+			if !fenced {
+				return fmt.Errorf("%s: missing code block", current.path)
+			}
+
+			var err error
+
+			if data, err = restoreText(data, current.attrs); err != nil {
+				return fmt.Errorf("%s: %w", current.path, err)
+			}
+		}
+
+		chunks <- fileChunk{path: current.path, attrs: current.attrs, data: data}
+
+		current = nil
+
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return summary, ctx.Err()
+		default:
+		}
+
+		line, err := reader.ReadString('\n') // returns line w/ '\n' or EOF
+		if err != nil && err != io.EOF && line == "" {
+			return summary, err
+		}
+
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		switch {
+		case fence != "":
+			if isClosingFence(trimmed, fence) {
+				fence = ""
+
+				break
+			}
+
+			if crlf && strings.HasSuffix(line, "\r\n") {
+				line = trimmed + "\n"
+			}
+
+			buf.WriteString(line)
+
+		case trimmed == treeHeading:
+			if err := flush(); err != nil {
+				return summary, err
+			}
+
+			summary.found = true
+
+		case summary.found:
+			summary.parse(trimmed)
+
+		case strings.HasPrefix(trimmed, headingPrefix):
+			if err := flush(); err != nil {
+				return summary, err
+			}
+
+			name, err := parseHeading(trimmed[len(headingPrefix):])
+			if err != nil {
+				return summary, err
+			}
+
+			current = &entry{path: name, attrs: Attributes{}}
+
+			buf.Reset()
+
+			fenced = false
+
+		case strings.HasPrefix(trimmed, commentPrefix):
+			attrs, err := parseComment(trimmed)
+			if err != nil {
+				return summary, err
+			}
+
+			if current == nil {
+				if err := checkVersion(attrs, trimmed); err != nil {
+					return summary, err
+				}
+
+				break
+			}
+
+			current.attrs = attrs
+
+		case current != nil && !fenced && openingFence(trimmed) != "":
+			fence = openingFence(trimmed)
+			fenced = true
+
+			// As in FormatAggr, an opening fence ending in CRLF means the archive's line endings were converted.
+			crlf = strings.HasSuffix(line, "\r\n")
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if fence != "" {
+		return summary, fmt.Errorf("unterminated code block for %q", current.path)
+	}
+
+	return summary, flush()
+}
+
+// comment renders attrs as an HTML comment line.
+func comment(attrs Attributes) string {
+	return commentPrefix + attrs.String() + " " + commentSuffix + "\n"
+}
+
+// parseComment parses the attributes of an HTML comment line rendered by comment.
+func parseComment(line string) (Attributes, error) {
+	block, ok := strings.CutSuffix(strings.TrimSpace(strings.TrimPrefix(line, commentPrefix)), commentSuffix)
+	if !ok {
+		return nil, fmt.Errorf("unterminated comment %q", line)
+	}
+
+	if block = strings.TrimSpace(block); block == "" {
+		return Attributes{}, nil
+	}
+
+	return parseAttributes(block)
+}
+
+// codeSpan renders text as an inline code span, delimited by more backticks than any run inside it.
+func codeSpan(text string) string {
+	delimiter := strings.Repeat("`", longestRun(text, '`')+1)
+
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+
+	return delimiter + text + delimiter
+}
+
+// parseHeading returns the path named by the text of an entry heading.
+// Headings that are not code spans, as sometimes produced by editing, are taken literally.
+func parseHeading(text string) (string, error) {
+	text = strings.TrimSpace(text)
+
+	if n := len(text) - len(strings.TrimLeft(text, "`")); n > 0 && n < len(text) {
+		delimiter := text[:n]
+
+		inner, ok := strings.CutSuffix(text[n:], delimiter)
+		if !ok {
+			return "", fmt.Errorf("unterminated code span in heading %q", text)
+		}
+
+		if len(inner) > 1 && strings.HasPrefix(inner, " ") && strings.HasSuffix(inner, " ") {
+			inner = inner[1 : len(inner)-1]
+		}
+
+		text = inner
+	}
+
+	return parsePath(text)
+}
+
+// fenceFor returns a backtick fence longer than any run of backticks in content.
+func fenceFor(content []byte) string {
+	return strings.Repeat("`", max(minFenceLength, longestRun(string(content), '`')+1))
+}
+
+// openingFence returns the fence that opens a code block on line, or an empty string if there is none.
+func openingFence(line string) string {
+	line = strings.TrimLeft(line, " ")
+
+	for _, char := range []byte{'`', '~'} {
+		n := len(line) - len(strings.TrimLeft(line, string(char)))
+		if n < minFenceLength {
+			continue
+		}
+
+		// Info strings of backtick fences cannot contain backticks.
+		if char == '`' && strings.Contains(line[n:], "`") {
+			return ""
+		}
+
+		return line[:n]
+	}
+
+	return ""
+}
+
+// isClosingFence reports whether line closes the code block opened by fence:
+// it holds only fence characters, at least as many as the opening fence.
+func isClosingFence(line, fence string) bool {
+	line = strings.TrimSpace(line)
+
+	return len(line) >= len(fence) && strings.Trim(line, fence[:1]) == ""
+}
+
+// longestRun returns the length of the longest run of char in text.
+func longestRun(text string, char byte) int {
+	longest, run := 0, 0
+
+	for i := range len(text) {
+		if text[i] != char {
+			run = 0
+
+			continue
+		}
+
+		run++
+		longest = max(longest, run)
+	}
+
+	return longest
+}
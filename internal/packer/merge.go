@@ -0,0 +1,193 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/idelchi/aggr/internal/diff"
+	"github.com/idelchi/godyl/pkg/path/file"
+)
+
+// ConflictStyle selects how a file that cannot be merged cleanly is written.
+type ConflictStyle string
+
+const (
+	// ConflictMarkers writes the merged file, with both versions of each conflicting region between conflict markers.
+	ConflictMarkers ConflictStyle = "markers"
+	// ConflictReject keeps the local file and writes the changes of the archive next to it, in a file with
+	// rejectSuffix added, as a unified diff against the base.
+	ConflictReject ConflictStyle = "rej"
+)
+
+// ConflictStyles lists the supported conflict styles.
+//
+//nolint:gochecknoglobals	// Read-only list of conflict styles.
+var ConflictStyles = []ConflictStyle{ConflictMarkers, ConflictReject}
+
+// ParseConflictStyle returns the conflict style with the given name. An empty name selects ConflictMarkers.
+func ParseConflictStyle(name string) (ConflictStyle, error) {
+	switch strings.ToLower(name) {
+	case "", string(ConflictMarkers):
+		return ConflictMarkers, nil
+	case string(ConflictReject), "reject":
+		return ConflictReject, nil
+	default:
+		return "", fmt.Errorf("unknown conflict style %q: must be one of %v", name, ConflictStyles)
+	}
+}
+
+// rejectSuffix is appended to the files holding changes that could not be merged.
+const rejectSuffix = ".rej"
+
+// Labels of the two versions in conflict markers.
+const (
+	labelLocal   = "local"
+	labelArchive = "archive"
+)
+
+// ReadEntries parses a packed stream and returns the content of its regular files, keyed by path.
+func (a *Aggregator) ReadEntries(ctx context.Context, reader io.Reader) (map[string][]byte, error) {
+	entries := map[string][]byte{}
+
+	errGroup, ctx := errgroup.WithContext(ctx)
+	chunks := make(chan fileChunk, a.Parallel)
+
+	errGroup.Go(func() error {
+		defer close(chunks)
+
+		_, err := a.parseStream(ctx, reader, chunks)
+
+		return err
+	})
+
+	var errs []error
+
+	for chunk := range chunks {
+		if chunk.attrs["type"] != "" {
+			continue
+		}
+
+		data, err := decode(chunk)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		entries[chunk.path] = data
+	}
+
+	errs = append(errs, errGroup.Wait())
+
+	return entries, errors.Join(errs...)
+}
+
+// merged is the outcome of merging an entry with the existing file.
+type merged struct {
+	// path is the path to write, relative to the destination.
+	path string
+	// data is the content to write.
+	data []byte
+	// kind classifies the outcome, or is empty if the entry is not merged.
+	kind ChangeKind
+	// attrs holds the metadata to restore on the written file.
+	attrs Attributes
+}
+
+// merge decides how to unpack a regular file entry with content data over the existing file at outputFile,
+// by comparing both with the entry at the same path in Base:
+//
+//   - changed in the archive only: the archive's version is written, without asking, as no local edits are lost
+//   - changed locally only: the local file is kept and reported as unchanged
+//   - changed on both sides: the changes are merged, and written with conflict markers or as a reject file
+//     according to ConflictStyle if they overlap. Content that is not text is never merged; the archive's
+//     version is written as a reject file instead.
+//
+// Merged files keep the mode of the local file. An empty outcome means the entry is not in Base,
+// or the existing entry is not a regular file, so the entry is unpacked as any other existing entry.
+func (a *Aggregator) merge(chunk fileChunk, outputFile file.File, data []byte) (merged, error) {
+	base, ok := a.Base[chunk.path]
+	if !ok {
+		return merged{}, nil
+	}
+
+	info, err := os.Lstat(outputFile.Path())
+	if err != nil || !info.Mode().IsRegular() {
+		return merged{}, nil //nolint:nilerr	// Entries that cannot be merged are unpacked as usual.
+	}
+
+	local, err := outputFile.Read()
+	if err != nil {
+		return merged{}, fmt.Errorf("read %s: %w", outputFile, err)
+	}
+
+	localAttrs := Attributes{"mode": fmt.Sprintf("%04o", info.Mode().Perm())}
+	reject := merged{path: chunk.path + rejectSuffix, data: data, kind: ChangeConflict}
+
+	switch {
+	case bytes.Equal(data, local), bytes.Equal(data, base):
+		return merged{path: chunk.path, kind: ChangeUnchanged}, nil
+	case bytes.Equal(local, base):
+		result := merged{path: chunk.path, data: data, kind: ChangeModified}
+		if a.Metadata {
+			result.attrs = restorable(chunk.attrs, data)
+		}
+
+		return result, nil
+	case !isDiffable(base) || !isDiffable(local) || !isDiffable(data):
+		return reject, nil
+	}
+
+	content, conflicts := diff.Merge(base, local, data, labelLocal, labelArchive)
+
+	switch {
+	case conflicts == 0:
+		return merged{path: chunk.path, data: content, kind: ChangeMerged, attrs: localAttrs}, nil
+	case a.ConflictStyle == ConflictReject:
+		reject.data = []byte(diff.Unified("a/"+chunk.path, "b/"+chunk.path, base, data))
+
+		return reject, nil
+	default:
+		return merged{path: chunk.path, data: content, kind: ChangeConflict, attrs: localAttrs}, nil
+	}
+}
+
+// writeMerged writes the outcome of merging an entry into dst, or into Staging if set.
+func (a *Aggregator) writeMerged(sink *filesSink, dst string, result merged) error {
+	outputFile := file.New(dst, result.path)
+
+	if result.kind == ChangeUnchanged {
+		sink.add(ChangeUnchanged, outputFile)
+
+		return nil
+	}
+
+	if err := validateParents(result.path, "", dst, a.Staging); err != nil {
+		a.reject(sink, result.path, err)
+
+		return nil
+	}
+
+	sink.add(result.kind, outputFile)
+
+	if a.Dry {
+		return nil
+	}
+
+	if a.Staging != "" {
+		outputFile = file.New(a.Staging, result.path)
+	}
+
+	if err := writeFile(outputFile, result.data); err != nil {
+		return err
+	}
+
+	return restoreMetadata(outputFile.Path(), result.attrs)
+}
@@ -0,0 +1,128 @@
+package packer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/idelchi/godyl/pkg/path/file"
+)
+
+func TestUnpackBase(t *testing.T) {
+	t.Parallel()
+
+	base := "a\nb\nc\n"
+
+	tests := []struct {
+		name    string
+		style   ConflictStyle
+		local   *string
+		archive string
+		// want holds the content of the files after unpacking, nil for files that must not exist.
+		want      map[string]*string
+		unchanged bool
+		conflict  bool
+	}{
+		{
+			name:      "deleted locally, unchanged in the archive",
+			archive:   base,
+			want:      map[string]*string{"file.txt": nil},
+			unchanged: true,
+		},
+		{
+			name:    "deleted locally, changed in the archive",
+			archive: "a\nB\nc\n",
+			want:    map[string]*string{"file.txt": ptr("a\nB\nc\n")},
+		},
+		{
+			name:      "changed locally only",
+			local:     ptr("A\nb\nc\n"),
+			archive:   base,
+			want:      map[string]*string{"file.txt": ptr("A\nb\nc\n")},
+			unchanged: true,
+		},
+		{
+			name:    "changed in the archive only",
+			local:   ptr(base),
+			archive: "a\nb\nC\n",
+			want:    map[string]*string{"file.txt": ptr("a\nb\nC\n")},
+		},
+		{
+			name:    "merged",
+			local:   ptr("A\nb\nc\n"),
+			archive: "a\nb\nC\n",
+			want:    map[string]*string{"file.txt": ptr("A\nb\nC\n")},
+		},
+		{
+			name:     "conflict with markers",
+			style:    ConflictMarkers,
+			local:    ptr("a\nL\nc\n"),
+			archive:  "a\nO\nc\n",
+			want:     map[string]*string{"file.txt": ptr("a\n<<<<<<< local\nL\n=======\nO\n>>>>>>> archive\nc\n")},
+			conflict: true,
+		},
+		{
+			name:    "conflict as reject file",
+			style:   ConflictReject,
+			local:   ptr("a\nL\nc\n"),
+			archive: "a\nO\nc\n",
+			want: map[string]*string{
+				"file.txt":     ptr("a\nL\nc\n"),
+				"file.txt.rej": ptr("--- a/file.txt\n+++ b/file.txt\n@@ -1,3 +1,3 @@\n a\n-b\n+O\n c\n"),
+			},
+			conflict: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := t.TempDir()
+
+			if test.local != nil {
+				if err := os.WriteFile(filepath.Join(dst, "file.txt"), []byte(*test.local), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			archive := packContents(t, newTestAggregator(t), map[string]string{"file.txt": test.archive})
+
+			a := newTestAggregator(t)
+			a.Base = map[string][]byte{"file.txt": []byte(base)}
+			a.ConflictStyle = test.style
+			a.Overwrite = PolicyFail
+
+			result := unpack(t, a, dst, archive)
+
+			for path, want := range test.want {
+				data, err := os.ReadFile(filepath.Join(dst, path))
+
+				switch {
+				case want == nil && err == nil:
+					t.Errorf("%s was written", path)
+				case want != nil && err != nil:
+					t.Errorf("%s: %v", path, err)
+				case want != nil && string(data) != *want:
+					t.Errorf("%s = %q, want %q", path, data, *want)
+				}
+			}
+
+			if got := slices.ContainsFunc(result.Unchanged, func(f file.File) bool {
+				return f.Path() == filepath.Join(dst, "file.txt")
+			}); got != test.unchanged {
+				t.Errorf("reported as unchanged: %v, want %v", got, test.unchanged)
+			}
+
+			if got := len(result.Conflicted) > 0; got != test.conflict {
+				t.Errorf("reported as conflicted: %v, want %v", got, test.conflict)
+			}
+		})
+	}
+}
+
+// ptr returns a pointer to text.
+func ptr(text string) *string {
+	return &text
+}
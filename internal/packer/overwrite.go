@@ -0,0 +1,115 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Policy decides what happens to an entry that already exists in the destination when unpacking.
+type Policy string
+
+const (
+	// PolicyPrompt asks whether to overwrite each existing entry, and fails if no one can be asked.
+	PolicyPrompt Policy = "prompt"
+	// PolicyForce overwrites existing entries.
+	PolicyForce Policy = "force"
+	// PolicySkip keeps existing entries and skips unpacking them.
+	PolicySkip Policy = "skip"
+	// PolicyBackup renames existing entries by adding backupSuffix before overwriting them.
+	PolicyBackup Policy = "backup"
+	// PolicyFail aborts unpacking at the first existing entry.
+	PolicyFail Policy = "fail"
+)
+
+// backupSuffix is appended to entries renamed by PolicyBackup.
+const backupSuffix = ".bak"
+
+// ErrExists is returned when an entry already exists in the destination and may not be overwritten.
+var ErrExists = errors.New("already exists")
+
+// resolveConflict applies the overwrite policy to the destination of an entry of the given type.
+// It returns false if the entry must be skipped. Directories that already exist are not conflicts,
+// as unpacking only adds to them. In dry run mode, nothing is renamed and no one is asked.
+func (a *Aggregator) resolveConflict(path, kind string) (bool, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if kind == typeDir && info.IsDir() {
+		return true, nil
+	}
+
+	switch a.Overwrite {
+	case PolicyForce:
+		return true, nil
+	case PolicySkip:
+		a.Logger.Debugf("  - %s: %v, skipping", path, ErrExists)
+
+		return false, nil
+	case PolicyBackup:
+		// Staged entries are backed up when they are committed.
+		if a.Staging != "" {
+			return true, nil
+		}
+
+		return true, a.backup(path)
+	case PolicyFail:
+		return false, fmt.Errorf("%q %w", path, ErrExists)
+	case PolicyPrompt:
+		if a.Dry {
+			return true, nil
+		}
+
+		if a.Confirm == nil {
+			break
+		}
+
+		if !a.Confirm(path) {
+			a.Logger.Debugf("  - %s: %v, skipping", path, ErrExists)
+
+			return false, nil
+		}
+
+		return true, nil
+	}
+
+	return false, fmt.Errorf(
+		"%q %w: pass --force, --skip-existing or --backup to resolve conflicts without prompting", path, ErrExists)
+}
+
+// backup renames the entry at path to its backup path, see backupPath.
+func (a *Aggregator) backup(path string) error {
+	target := backupPath(path)
+
+	if a.Dry {
+		return nil
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("backup %s: %w", path, err)
+	}
+
+	a.Logger.Infof("Backed up %q to %q", path, target)
+
+	return nil
+}
+
+// backupPath returns the first free name formed by adding backupSuffix and, if needed, a number to path.
+func backupPath(path string) string {
+	target := path + backupSuffix
+
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(target); errors.Is(err, fs.ErrNotExist) {
+			return target
+		}
+
+		target = fmt.Sprintf("%s%s.%d", path, backupSuffix, n)
+	}
+}
@@ -1,20 +1,26 @@
 package packer
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/dustin/go-humanize"
 
 	"github.com/idelchi/aggr/internal/checkers"
 	"github.com/idelchi/aggr/internal/config"
 	"github.com/idelchi/aggr/internal/patterns"
+	"github.com/idelchi/aggr/internal/tokens"
 	"github.com/idelchi/aggr/internal/walker"
 	gitignore "github.com/idelchi/go-gitignore"
+	"github.com/idelchi/godyl/pkg/logger"
 	"github.com/idelchi/godyl/pkg/path/file"
+	"github.com/idelchi/godyl/pkg/path/files"
 	"github.com/idelchi/godyl/pkg/pretty"
 )
 
@@ -24,14 +30,22 @@ import (
 //
 //nolint:gocognit,funlen	// TODO(Idelchi): Refactor this function to reduce complexity.
 func (p Packer) Pack(searchPatterns []string) error {
+	format, err := ParseFormat(p.Options.Format)
+	if err != nil {
+		return err
+	}
+
 	if p.Options.Output == "" {
 		path, err := filepath.Abs(p.Options.Rules.Root)
 		if err != nil {
 			return err
 		}
 
-		//nolint:perfsprint  // More readable this way.
-		p.Options.Output = fmt.Sprintf("%s.aggr", filepath.Base(path))
+		p.Options.Output = filepath.Base(path) + format.Extension()
+
+		if p.Options.Compress {
+			p.Options.Output += compressedExtension
+		}
 	}
 
 	log, err := Logger(p.Options.Dry)
@@ -48,10 +62,155 @@ func (p Packer) Pack(searchPatterns []string) error {
 		fmt.Printf("args: %v\n", searchPatterns)
 	}
 
+	marker, err := ResolveMarker(p.Options.Marker)
+	if err != nil {
+		return err
+	}
+
+	var split uint64
+
+	if p.Options.Split != "" {
+		if split, err = humanize.ParseBytes(p.Options.Split); err != nil || split == 0 {
+			return fmt.Errorf("parsing split size %q: must be a positive size", p.Options.Split)
+		}
+
+		if p.Options.IsStdout() {
+			return errors.New("cannot split output written to stdout: use --output/-o to name the archive")
+		}
+	}
+
+	excludes := []string{}
+
+	// Add output file to excludes if specified
+	if !p.Options.IsStdout() {
+		excludes = append(excludes, p.Options.Output)
+
+		if split > 0 {
+			excludes = append(excludes, partPattern(p.Options.Output))
+		}
+	}
+
+	files, scope, err := p.collect(log, searchPatterns, excludes...)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		log.Warn("No files found matching the specified patterns and rules")
+
+		return nil
+	}
+
+	if p.Options.Dry {
+		p.Options.Output = "" // In dry run mode, we don't write anything
+		p.Options.Compress = false
+	}
+
+	aggregator := NewAggregator(
+		log,
+		p.Options.Dry,
+		p.Options.Parallel,
+		p.Options.Rules.Root,
+	)
+	aggregator.Prefixes = NewPrefixes(marker)
+	aggregator.Format = format
+
+	// Files left out by the token budget depend on the files before them, so such archives cannot tell
+	// which files in scope they lack.
+	if p.Options.Rules.MaxTokens == 0 {
+		aggregator.Scope = &scope
+	}
+
+	if split > 0 && !p.Options.Dry {
+		//nolint:gosec		// Cannot overflow for any realistic size.
+		parts, err := aggregator.PackSplit(files, int64(split), func(part int) (io.WriteCloser, error) {
+			options := p.Options
+			options.Output = partName(p.Options.Output, part)
+
+			return GetOutputWriter(options)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to aggregate files: %w", err)
+		}
+
+		log.Infof("Successfully packed %d files into %d parts, %s to %s",
+			len(files), parts, partName(p.Options.Output, 1), partName(p.Options.Output, parts))
+
+		removed, err := removeStaleParts(p.Options.Output, parts)
+		if err != nil {
+			return fmt.Errorf("removing parts left over from an earlier split: %w", err)
+		}
+
+		if removed > 0 {
+			log.Infof("Removed %d parts left over from an earlier split, up to %s",
+				removed, partName(p.Options.Output, parts+removed))
+		}
+
+		return nil
+	}
+
+	// Get output writer
+	writer, err := GetOutputWriter(p.Options)
+	if err != nil {
+		return err
+	}
+
+	defer writer.Close()
+
+	if err := aggregator.Pack(files, writer); err != nil {
+		return fmt.Errorf("failed to aggregate files: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("closing output: %w", err)
+	}
+
+	// Show completion message
+	if !p.Options.IsStdout() {
+		log.Infof("Successfully packed %d files into %s", len(files), p.Options.Output)
+	}
+
+	return nil
+}
+
+// collect returns the files under the root directory that match the search patterns and pass all
+// filtering rules, sorted by path, as they would be packed, along with the scope they were collected from.
+// Paths matching excludes, such as the output file, are never collected.
+func (p Packer) collect(log *logger.Logger, searchPatterns []string, excludes ...string) (files.Files, Scope, error) {
+	scope, err := p.scope(log, searchPatterns, excludes...)
+	if err != nil {
+		return nil, scope, err
+	}
+
+	var extras []string
+
+	// Exclude the executable itself
+	if exe, err := os.Executable(); err == nil {
+		path := file.New(exe).Path()
+		log.Debugf("- Excluding the executable: %q", path)
+
+		extras = append(extras, path)
+	}
+
+	checks := scope.checkers(extras...)
+
+	// The token budget is only spent on files that pass all other checks.
+	if p.Options.Rules.MaxTokens > 0 {
+		checks = append(checks, checkers.NewTokens(p.Options.Rules.MaxTokens, tokens.Approximate{}))
+	}
+
+	files, err := walk(log, p.Options.Rules.Root, scope.Patterns, checks, p.Options.Rules.Max)
+
+	return files, scope, err
+}
+
+// scope returns the scope of packing the search patterns with the configured filtering rules.
+// Paths matching excludes are left out of it.
+func (p Packer) scope(log *logger.Logger, searchPatterns []string, excludes ...string) (Scope, error) {
 	search := patterns.Patterns(searchPatterns)
 
 	if err := search.Validate(); err != nil {
-		return fmt.Errorf(
+		return Scope{}, fmt.Errorf(
 			"validating search patterns: %w:\nuse --root/-C <path> to specify a different root directory",
 			err,
 		)
@@ -63,9 +222,26 @@ func (p Packer) Pack(searchPatterns []string) error {
 
 	bytes, err := humanize.ParseBytes(p.Options.Rules.Size)
 	if err != nil {
-		return fmt.Errorf("parsing size value %q: %w", p.Options.Rules.Size, err)
+		return Scope{}, fmt.Errorf("parsing size value %q: %w", p.Options.Rules.Size, err)
+	}
+
+	ignorePatterns, err := p.ignores(log, excludes...)
+	if err != nil {
+		return Scope{}, err
 	}
 
+	return Scope{
+		Patterns: search,
+		Ignore:   ignorePatterns,
+		//nolint:gosec		// Cannot overflow for any realistic size.
+		Size:   int(bytes),
+		Binary: p.Options.Rules.Binary,
+	}, nil
+}
+
+// ignores returns the ignore patterns of the configured filtering rules, in the order they are applied,
+// followed by the defaults. Paths matching excludes are ignored as well.
+func (p Packer) ignores(log *logger.Logger, excludes ...string) (patterns.Patterns, error) {
 	ignorePatterns := patterns.Patterns{}
 
 	log.Debug("- Adding ignore patterns:")
@@ -90,39 +266,22 @@ func (p Packer) Pack(searchPatterns []string) error {
 
 	ignorePatterns = append(ignorePatterns, p.Options.Rules.Patterns...)
 
-	// Exclude the executable itself
-	if exe, err := os.Executable(); err == nil {
-		path := file.New(exe).Path()
-		log.Debugf("  - the executable: %q", path)
-
-		ignorePatterns = append(ignorePatterns, path)
-	}
-
-	// Add output file to excludes if specified
-	if !p.Options.IsStdout() {
-		log.Debugf("  - the output file: %q", p.Options.Output)
+	// Exclude the output, such as the output file or the archives being compared
+	for _, exclude := range excludes {
+		log.Debugf("  - the output: %q", exclude)
 
-		ignorePatterns = append(ignorePatterns, p.Options.Output)
+		ignorePatterns = append(ignorePatterns, exclude)
 	}
 
-	var aggrignore file.File
-
-	if !p.Options.Rules.IgnoreFile.Set || p.Options.Rules.IgnoreFile.Path != "" {
-		if !p.Options.Rules.IgnoreFile.Set {
-			aggrignore = DefaultAggrignores()
-		} else {
-			aggrignore = file.New(p.Options.Rules.IgnoreFile.Path)
-
-			if !aggrignore.Exists() {
-				return fmt.Errorf("ignore file %q does not exist", aggrignore)
-			}
-		}
+	aggrignore, err := p.ignoreFile()
+	if err != nil {
+		return nil, err
 	}
 
 	if aggrignore.Set() {
 		lines, err := aggrignore.Lines()
 		if err != nil {
-			return fmt.Errorf("reading %q: %w", aggrignore, err)
+			return nil, fmt.Errorf("reading %q: %w", aggrignore, err)
 		}
 
 		ignorePatterns = append(ignorePatterns, patterns.Patterns(lines).TrimEmpty()...)
@@ -136,8 +295,6 @@ func (p Packer) Pack(searchPatterns []string) error {
 
 	ignorePatterns = append(ignorePatterns, config.DefaultExcludes...)
 
-	ignorer := ignorePatterns.AsGitIgnore()
-
 	if len(ignorePatterns) > 0 {
 		log.Debug("- The following patterns will be applied:")
 
@@ -146,69 +303,69 @@ func (p Packer) Pack(searchPatterns []string) error {
 		}
 	}
 
-	checks := []checkers.Checker{
-		checkers.NewIgnore(ignorer),
-		//nolint:gosec		// Cannot be negative.
-		checkers.NewSize(int(bytes)),
-	}
-
-	if !p.Options.Rules.Binary {
-		checks = append(checks, checkers.NewBinary())
-	}
+	return ignorePatterns, nil
+}
 
-	walker := walker.New(checks, p.Options.Rules.Max, log)
+// ignoreFile returns the ignore file to load: the one passed, or the default one if none is passed.
+// It returns an unset file if loading an ignore file is disabled.
+func (p Packer) ignoreFile() (file.File, error) {
+	var aggrignore file.File
 
-	for _, path := range search {
-		log.Debugf("\n- Processing pattern: %v", path)
+	switch {
+	case !p.Options.Rules.IgnoreFile.Set:
+		aggrignore = DefaultAggrignores()
+	case p.Options.Rules.IgnoreFile.Path != "":
+		aggrignore = file.New(p.Options.Rules.IgnoreFile.Path)
 
-		if err := walker.Walk(os.DirFS(p.Options.Rules.Root), path); err != nil {
-			return fmt.Errorf("matching pattern %q: %w", path, err)
+		if !aggrignore.Exists() {
+			return aggrignore, fmt.Errorf("ignore file %q does not exist", aggrignore)
 		}
 	}
 
-	files := walker.Files
+	return aggrignore, nil
+}
 
-	if len(files) == 0 {
-		log.Warn("No files found matching the specified patterns and rules")
+// checkers returns the checkers that apply the ignore patterns of the scope, followed by extras,
+// its size limit and, unless binary files are included, the binary file check.
+func (s Scope) checkers(extras ...string) checkers.Checkers {
+	ignorePatterns := append(patterns.Patterns(slices.Clone(s.Ignore)), extras...)
 
-		return nil
+	checks := checkers.Checkers{
+		checkers.NewIgnore(ignorePatterns.AsGitIgnore()),
+		checkers.NewSize(s.Size),
 	}
 
-	slices.SortFunc(files, func(a, b file.File) int {
-		return strings.Compare(strings.ToLower(a.Path()), strings.ToLower(b.Path()))
-	})
-
-	if p.Options.Dry {
-		p.Options.Output = "" // In dry run mode, we don't write anything
+	if !s.Binary {
+		checks = append(checks, checkers.NewBinary())
 	}
 
-	aggregator := NewAggregator(
-		log,
-		p.Options.Dry,
-		p.Options.Parallel,
-		p.Options.Rules.Root,
-	)
+	return checks
+}
 
-	// Get output writer
-	writer, err := GetOutputWriter(p.Options)
-	if err != nil {
-		return err
-	}
+// walk returns the files under root that match the search patterns and pass all checks, sorted by path.
+// Staging directories are skipped. It fails if more than maxFiles files are found.
+func walk(
+	log *logger.Logger,
+	root string,
+	search []string,
+	checks checkers.Checkers,
+	maxFiles int,
+) (files.Files, error) {
+	walker := walker.New(append(checkers.Checkers{staged}, checks...), maxFiles, log)
 
-	defer func() {
-		if writer != os.Stdout {
-			_ = writer.Close()
-		}
-	}()
+	for _, path := range search {
+		log.Debugf("\n- Processing pattern: %v", path)
 
-	if err := aggregator.Pack(files, writer); err != nil {
-		return fmt.Errorf("failed to aggregate files: %w", err)
+		if err := walker.Walk(os.DirFS(root), path, doublestar.WithNoFollow()); err != nil {
+			return nil, fmt.Errorf("matching pattern %q: %w", path, err)
+		}
 	}
 
-	// Show completion message
-	if !p.Options.IsStdout() {
-		log.Infof("Successfully packed %d files into %s", len(files), p.Options.Output)
-	}
+	files := walker.Files
 
-	return nil
+	slices.SortFunc(files, func(a, b file.File) int {
+		return strings.Compare(strings.ToLower(a.Path()), strings.ToLower(b.Path()))
+	})
+
+	return files, nil
 }
@@ -2,10 +2,13 @@ package packer
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"sync"
+
+	"github.com/mattn/go-isatty"
 
 	"github.com/idelchi/aggr/internal/config"
-	"github.com/idelchi/godyl/pkg/path/folder"
 )
 
 // Packer orchestrates the file packing and unpacking processes.
@@ -14,22 +17,45 @@ type Packer struct {
 	Options config.Options
 }
 
-// PromptForFolderExists prompts the user for confirmation if the target folder already exists.
-// It returns true if the user confirms to proceed, false otherwise.
+// NewPrompt returns a function that asks on the console whether an existing file may be overwritten.
+// Answering "a" overwrites this and all following files without asking again.
+// Questions are asked one at a time, so the function is safe for concurrent use.
 //
 //nolint:forbidigo	// Function prints out to the console.
-func PromptForFolderExists(folder folder.Folder) bool {
-	if !folder.Exists() {
-		return true
-	}
+func NewPrompt() func(path string) bool {
+	var (
+		mu  sync.Mutex
+		all bool
+	)
+
+	return func(path string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if all {
+			return true
+		}
 
-	fmt.Printf("The folder %q already exists.\n", folder)
-	fmt.Println("This may overwrite existing files. Proceed with caution.")
-	fmt.Print("Continue? (y/N): ")
+		fmt.Printf("The file %q already exists. Overwrite? (y/N/a for all): ", path)
 
-	var response string
+		var response string
 
-	_, _ = fmt.Scanln(&response)
+		_, _ = fmt.Scanln(&response)
+
+		switch strings.ToLower(response) {
+		case "a":
+			all = true
+
+			return true
+		case "y":
+			return true
+		default:
+			return false
+		}
+	}
+}
 
-	return strings.ToLower(response) == "y"
+// IsTerminal reports whether file is an interactive terminal.
+func IsTerminal(file *os.File) bool {
+	return isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd())
 }
@@ -0,0 +1,97 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// validatePath refuses entry paths that are empty, absolute or escape the destination directory, and paths
+// with a name reserved for staging directories.
+// Archives may be crafted or written by a model, so no path can be trusted.
+func validatePath(path string) error {
+	native := filepath.FromSlash(path)
+
+	switch {
+	case path == "":
+		return errors.New("empty path")
+	case filepath.IsAbs(native), filepath.VolumeName(native) != "",
+		strings.HasPrefix(path, "/"), strings.HasPrefix(path, `\`):
+		return errors.New("absolute paths are not allowed")
+	case !filepath.IsLocal(native):
+		return errors.New("path escapes the destination")
+	case slices.ContainsFunc(strings.Split(filepath.ToSlash(native), "/"), isStaging):
+		return fmt.Errorf("names starting with %q are reserved for staging", stagePrefix)
+	}
+
+	return nil
+}
+
+// isStaging reports whether name is the name of a staging directory.
+func isStaging(name string) bool {
+	return strings.HasPrefix(name, stagePrefix)
+}
+
+// validateParents refuses entries that would be written through a symbolic link below any of the roots, such as
+// the destination and the staging directory: every existing parent directory of path must be a real directory,
+// and the entry itself may only be a symbolic link if it is replaced by one. Otherwise, a link in the destination,
+// whether it existed before or was unpacked from the archive, could redirect writes outside of it.
+// Entries that would replace a directory with an entry of another type, or need a directory where a file is,
+// are refused as well, so that moving the staged entries into place cannot fail on them.
+// entryType is the type attribute of the entry. Empty roots are skipped.
+func validateParents(path, entryType string, roots ...string) error {
+	parts := strings.Split(filepath.Clean(filepath.FromSlash(path)), string(filepath.Separator))
+
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+
+		if err := validateParentsIn(root, parts, entryType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateParentsIn checks the parents of the path made of parts below root, see validateParents.
+func validateParentsIn(root string, parts []string, entryType string) error {
+	current := root
+
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		parent := filepath.ToSlash(filepath.Join(parts[:i+1]...))
+
+		switch {
+		case info.Mode()&os.ModeSymlink == 0 && info.IsDir():
+			if i == len(parts)-1 && entryType != typeDir {
+				return errors.New("an existing directory would be replaced")
+			}
+		case info.Mode()&os.ModeSymlink == 0:
+			if i < len(parts)-1 {
+				return fmt.Errorf("parent directory %q is a file", parent)
+			}
+		case i < len(parts)-1:
+			return fmt.Errorf("parent directory %q is a symbolic link", parent)
+		case entryType != typeSymlink:
+			return errors.New("an existing symbolic link would be written through")
+		}
+	}
+
+	return nil
+}
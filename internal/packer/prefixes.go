@@ -0,0 +1,146 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Prefixes defines the markers and tokens used in the packed stream format.
+type Prefixes struct {
+	// Marker is the common prefix used for BEGIN and END markers.
+	Marker string
+	// Begin is the suffix that indicates the start of a file section.
+	Begin string
+	// End is the suffix that indicates the end of a file section.
+	End string
+	// Escape is Marker with a single escape character inserted, used when Marker appears inside file content.
+	// Content lines that already carry escape characters receive one more, so escaping nests without loss.
+	Escape string
+}
+
+// DefaultMarker is the marker used when none is configured.
+const DefaultMarker = "// === AGGR:"
+
+// MarkerPresets maps the names of the built-in marker presets to their markers.
+//
+//nolint:gochecknoglobals 	// Fair use of global variables.
+var MarkerPresets = map[string]string{
+	"default": DefaultMarker,
+	"hash":    "# === AGGR:",
+	"dash":    "-- === AGGR:",
+	"angle":   "<<< AGGR:",
+}
+
+// ResolveMarker returns the marker for a preset name, or name itself as a custom marker.
+// Custom markers must be a single line that does not start with whitespace or the format header prefix.
+func ResolveMarker(name string) (string, error) {
+	if marker, ok := MarkerPresets[name]; ok {
+		return marker, nil
+	}
+
+	switch {
+	case strings.TrimSpace(name) == "":
+		return "", errors.New("marker must not be empty")
+	case strings.ContainsAny(name, "\r\n"):
+		return "", fmt.Errorf("marker %q must not contain line breaks", name)
+	case strings.TrimLeft(name, " \t") != name:
+		return "", fmt.Errorf("marker %q must not start with whitespace", name)
+	case strings.HasPrefix(name, headerPrefix):
+		return "", fmt.Errorf("marker %q must not start with %q", name, headerPrefix)
+	}
+
+	return name, nil
+}
+
+// NewPrefixes creates the prefixes for the given marker.
+// The escaped form inserts a backslash before the last space of the marker,
+// or after its first character if it contains no space.
+func NewPrefixes(marker string) Prefixes {
+	split := strings.LastIndex(marker, " ")
+	if split < 0 {
+		split = min(1, len(marker))
+	}
+
+	return Prefixes{
+		Marker: marker,
+		Begin:  "BEGIN:",
+		End:    "END:",
+		Escape: marker[:split] + "\\" + marker[split:],
+	}
+}
+
+// beginPrefix returns the full BEGIN marker used during parsing.
+func (p Prefixes) beginPrefix() string { return fmt.Sprintf("%s %s", p.Marker, p.Begin) }
+
+// endPrefix returns the full END marker used during parsing.
+func (p Prefixes) endPrefix() string { return fmt.Sprintf("%s %s", p.Marker, p.End) }
+
+// escapeOffset returns the position within Marker at which Escape inserts its escape character.
+func (p Prefixes) escapeOffset() int {
+	offset := 0
+	for offset < len(p.Marker) && offset < len(p.Escape) && p.Marker[offset] == p.Escape[offset] {
+		offset++
+	}
+
+	return offset
+}
+
+// escapeChar returns the escape character that Escape inserts into Marker.
+func (p Prefixes) escapeChar() string {
+	return p.Escape[p.escapeOffset() : p.escapeOffset()+len(p.Escape)-len(p.Marker)]
+}
+
+// escapeLevel reports whether line starts, after optional spaces and tabs, with the marker
+// carrying any number of escape characters at the escape position.
+// It returns the offset within line where escape characters are inserted and how many are present.
+func (p Prefixes) escapeLevel(line string) (offset, level int, ok bool) {
+	split := p.escapeOffset()
+	head, tail, char := p.Marker[:split], p.Marker[split:], p.escapeChar()
+
+	trimmed := strings.TrimLeft(line, " \t")
+	if !strings.HasPrefix(trimmed, head) {
+		return 0, 0, false
+	}
+
+	offset = len(line) - len(trimmed) + len(head)
+	rest := line[offset:]
+
+	for strings.HasPrefix(rest, char) {
+		rest = rest[len(char):]
+		level++
+	}
+
+	if !strings.HasPrefix(rest, tail) {
+		return 0, 0, false
+	}
+
+	return offset, level, true
+}
+
+// escape adds one escape level to every line that starts with the marker,
+// in plain or already escaped form, after optional indentation.
+// Escaping is reversible at every nesting level, so archives can be packed into archives.
+func (p Prefixes) escape(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if offset, _, ok := p.escapeLevel(line); ok {
+			lines[i] = line[:offset] + p.escapeChar() + line[offset:]
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// unescape removes one escape level from every line that starts with an escaped marker,
+// undoing escape.
+func (p Prefixes) unescape(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if offset, level, ok := p.escapeLevel(line); ok && level > 0 {
+			lines[i] = line[:offset] + line[offset+len(p.escapeChar()):]
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
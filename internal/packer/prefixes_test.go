@@ -0,0 +1,74 @@
+package packer
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// escaped returns the marker of p carrying level escape characters.
+func escaped(p Prefixes, level int) string {
+	offset := p.escapeOffset()
+
+	return p.Marker[:offset] + strings.Repeat(p.escapeChar(), level) + p.Marker[offset:]
+}
+
+func TestMarkerRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	markers := slices.Sorted(maps.Keys(MarkerPresets))
+	markers = append(markers, "@@ custom marker @@", "%%")
+
+	for _, name := range markers {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			marker, err := ResolveMarker(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prefixes := NewPrefixes(marker)
+
+			var lines []string
+
+			for level := range 5 {
+				for _, indent := range []string{"", "  ", "\t", " \t "} {
+					line := indent + escaped(prefixes, level)
+					lines = append(lines,
+						line+" BEGIN: main.go",
+						line+" END: main.go",
+						line+" BEGIN: main.go [sha256=0]",
+						line,
+						line+" trailing text",
+					)
+				}
+			}
+
+			contents := map[string]string{
+				"levels.txt":   strings.Join(lines, "\n") + "\n",
+				"no-eol.txt":   prefixes.beginPrefix() + " x",
+				"unrelated.md": "# " + marker + "\n" + marker[:len(marker)-1] + "\n",
+			}
+
+			// Pack the archive into an archive, and that one again, so that every level is escaped again.
+			for depth := range 3 {
+				a := newTestAggregator(t)
+				a.Prefixes = prefixes
+
+				archive := packContents(t, a, contents)
+
+				if got := readEntries(t, a, archive); !maps.Equal(got, contents) {
+					t.Fatalf("depth %d: entries differ after round-trip:\ngot  %q\nwant %q", depth, got, contents)
+				}
+
+				contents = map[string]string{
+					"archive.aggr": archive,
+					"indented.txt": "    " + strings.ReplaceAll(archive, "\n", "\n    "),
+					"levels.txt":   contents["levels.txt"],
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package packer
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/idelchi/aggr/internal/checkers"
+	"github.com/idelchi/godyl/pkg/logger"
+	"github.com/idelchi/godyl/pkg/path/file"
+	"github.com/idelchi/godyl/pkg/path/files"
+	"github.com/idelchi/godyl/pkg/path/folder"
+)
+
+// stale returns the files under output that are in the scope recorded in the unpacked archives but have
+// no entry in them, sorted by path. Files excluded from unpacking by the checkers and the files in keep,
+// such as the archives being unpacked, are never stale, nor are staged files, which walking skips.
+func (p Packer) stale(
+	log *logger.Logger,
+	result Unpacked,
+	output folder.Folder,
+	keep []string,
+	chk checkers.Checkers,
+) (files.Files, error) {
+	if result.Scope == nil {
+		return nil, fmt.Errorf(
+			"cannot prune %q: the archive does not record which files it covers, "+
+				"pack it again with a version of aggr that does and without --max-tokens",
+			output,
+		)
+	}
+
+	if !output.Exists() {
+		return nil, nil
+	}
+
+	checks := append(result.Scope.checkers(), chk...)
+
+	inScope, err := walk(log, output.Path(), result.Scope.Patterns, checks, math.MaxInt)
+	if err != nil {
+		return nil, fmt.Errorf("collecting files to prune in %q: %w", output, err)
+	}
+
+	entries := make(map[string]bool, len(result.Entries))
+	for _, entry := range result.Entries {
+		entries[filepath.ToSlash(entry)] = true
+	}
+
+	kept := make(map[string]bool, len(keep))
+
+	for _, path := range keep {
+		if absolute, err := filepath.Abs(path); err == nil && path != "" {
+			kept[absolute] = true
+		}
+	}
+
+	var stale files.Files
+
+	for _, candidate := range inScope {
+		absolute, err := filepath.Abs(file.New(output.Path(), candidate.Path()).Path())
+		if err != nil || kept[absolute] || entries[filepath.ToSlash(candidate.Path())] {
+			continue
+		}
+
+		stale.AddFile(candidate)
+	}
+
+	return stale, nil
+}
+
+// Prune deletes the stale files, given relative to dst, and the folders they leave empty, up to dst.
+// Folders that are entries themselves are kept. With PolicyBackup, files are renamed as backups instead.
+// In dry run mode, nothing is deleted.
+func (a *Aggregator) Prune(dst string, stale files.Files, entries []string) error {
+	if a.Dry {
+		return nil
+	}
+
+	kept := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		kept[filepath.Clean(entry)] = true
+	}
+
+	for _, path := range stale {
+		target := file.New(dst, path.Path())
+
+		if a.Overwrite == PolicyBackup {
+			if info, err := os.Lstat(target.Path()); err == nil && !info.IsDir() {
+				if err := a.backup(target.Path()); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if err := os.Remove(target.Path()); err != nil {
+			return fmt.Errorf("delete %s: %w", target, err)
+		}
+
+		// Remove the folders left empty, stopping at the first one that is not.
+		parent := filepath.Dir(filepath.Clean(path.Path()))
+
+		for ; parent != "." && !kept[parent]; parent = filepath.Dir(parent) {
+			if os.Remove(filepath.Join(dst, parent)) != nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
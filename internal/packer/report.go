@@ -0,0 +1,99 @@
+package packer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/idelchi/aggr/internal/tree"
+	"github.com/idelchi/godyl/pkg/path/file"
+	"github.com/idelchi/godyl/pkg/path/files"
+)
+
+// report summarizes the packed entries for a footer.
+type report struct {
+	// set holds the packed files.
+	set files.Files
+	// digest is the archive digest, empty in dry run mode.
+	digest string
+	// tokens holds the estimated number of tokens of each entry, keyed by path.
+	tokens map[string]int
+	// scope is the scope of the archive, if recorded.
+	scope *Scope
+	// part records which part of a split archive the archive is, if it is one.
+	part *Part
+}
+
+// total returns the estimated number of tokens of all entries.
+func (r report) total() int {
+	total := 0
+
+	for _, count := range r.tokens {
+		total += count
+	}
+
+	return total
+}
+
+// lines returns the footer lines holding the file count, the estimated number of tokens and,
+// if set, the scope, the part and the archive digest.
+func (r report) lines() string {
+	lines := fmt.Sprintf("%d%s\n~%d%s\n", len(r.set), footerFiles, r.total(), footerTokens)
+
+	if r.scope != nil {
+		lines += scopePrefix + r.scope.String() + "\n"
+	}
+
+	if r.part != nil {
+		lines += partPrefix + r.part.String() + "\n"
+	}
+
+	if r.digest != "" {
+		lines += digestPrefix + r.digest + "\n"
+	}
+
+	return lines
+}
+
+// label returns the annotation of each file in the footer tree:
+// its number of lines in dry run mode, and its estimated number of tokens.
+func (a *Aggregator) label(r report) func(file.File) string {
+	return func(path file.File) string {
+		var labels []string
+
+		if a.Dry {
+			lines, _ := file.New(a.Root, path.Path()).NumberOfLines()
+			labels = append(labels, fmt.Sprintf("#%d", lines))
+		}
+
+		if count := r.tokens[path.Path()]; count > 0 {
+			labels = append(labels, fmt.Sprintf("~%d%s", count, footerTokens))
+		}
+
+		return strings.Join(labels, ", ")
+	}
+}
+
+// fill adds the figures shown by label to the nodes of a structured tree.
+func (a *Aggregator) fill(r report) func(file.File, *tree.Node) {
+	return func(path file.File, node *tree.Node) {
+		if a.Dry {
+			node.Lines, _ = file.New(a.Root, path.Path()).NumberOfLines()
+		}
+
+		node.Tokens = r.tokens[path.Path()]
+	}
+}
+
+// estimate returns the estimated number of tokens of every entry in set, without packing them.
+// Entries that cannot be read are left out, as they are reported when packing.
+func (a *Aggregator) estimate(set files.Files) map[string]int {
+	tokens := make(map[string]int, len(set))
+
+	for _, path := range set {
+		if packedEntry, err := a.packFile(path); err == nil {
+			tokens[path.Path()] = a.Tokenizer.Count(packedEntry.data)
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,41 @@
+package packer
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// scopePrefix is the prefix of the footer line holding the scope of the archive.
+const scopePrefix = "scope: "
+
+// Scope records which paths under the root directory an archive covers: the paths that packing
+// with the same search patterns and filtering rules would consider. It is recorded in the footer,
+// so unpacking can tell files that were removed since from files that were never packed.
+type Scope struct {
+	// Patterns holds the normalized search patterns.
+	Patterns []string `json:"patterns"`
+	// Ignore holds the ignore patterns in the order they were applied, including those from the ignore file.
+	Ignore []string `json:"ignore,omitempty"`
+	// Size is the maximum size of included files in bytes.
+	Size int `json:"size"`
+	// Binary indicates whether binary files were included.
+	Binary bool `json:"binary,omitempty"`
+}
+
+// String renders the scope as compact JSON.
+func (s Scope) String() string {
+	data, _ := json.Marshal(s)
+
+	return string(data)
+}
+
+// parseScope parses a scope rendered by String.
+func parseScope(text string) (*Scope, error) {
+	var scope Scope
+
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &scope); err != nil {
+		return nil, err
+	}
+
+	return &scope, nil
+}
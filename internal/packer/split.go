@@ -0,0 +1,322 @@
+package packer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/idelchi/godyl/pkg/path/files"
+)
+
+// partPrefix is the prefix of the footer line recording the part of a split archive.
+const partPrefix = "part: "
+
+// Part records which part of a split archive an archive is, in the footer of every part. The number of parts
+// is not known until the last one is written, so each part records the number of files of the whole archive
+// instead: the parts of an archive are complete once they hold that many entries.
+type Part struct {
+	// Index is the number of the part, counted from 1.
+	Index int `json:"index"`
+	// Files is the number of files in all parts of the archive.
+	Files int `json:"files"`
+	// ID identifies the archive the part belongs to, by the checksum of the paths of all its files.
+	ID string `json:"id"`
+}
+
+// String renders the part as compact JSON.
+func (p Part) String() string {
+	data, _ := json.Marshal(p)
+
+	return string(data)
+}
+
+// parsePart parses a part rendered by String.
+func parsePart(text string) (*Part, error) {
+	var part Part
+
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &part); err != nil {
+		return nil, err
+	}
+
+	return &part, nil
+}
+
+// partID returns the ID of the split archive holding the files of set, see Part.
+func partID(set files.Files) string {
+	var paths strings.Builder
+
+	for _, path := range set {
+		paths.WriteString(path.Path() + "\n")
+	}
+
+	return checksum([]byte(paths.String()))[:partIDLength]
+}
+
+// partIDLength is the number of hexadecimal digits of the ID of a split archive.
+const partIDLength = 16
+
+// part is an archive part being written by PackSplit.
+type part struct {
+	// writer receives the content of the part.
+	writer io.WriteCloser
+	// set holds the files packed into the part so far.
+	set files.Files
+	// entries accumulates the digest of the part.
+	entries *manifest
+	// tokens holds the estimated number of tokens of each file in the part.
+	tokens map[string]int
+	// size is the number of bytes written so far.
+	size int64
+	// scope is the scope recorded in the footer, if any.
+	scope *Scope
+	// info records which part of the archive the part is.
+	info *Part
+	// footer is an upper bound of the size of the footer of the part so far.
+	footer int64
+}
+
+// write writes data to the part and accounts for its size.
+func (p *part) write(data []byte) error {
+	n, err := p.writer.Write(data)
+	p.size += int64(n)
+
+	return err
+}
+
+// finish writes the footer of the part and closes it.
+func (p *part) finish(codec codec) error {
+	if err := p.write([]byte(codec.footer(p.report(p.entries.digest())))); err != nil {
+		p.writer.Close()
+
+		return err
+	}
+
+	return p.writer.Close()
+}
+
+// report returns the summary of the part with the given digest.
+func (p *part) report(digest string) report {
+	return report{set: p.set, digest: digest, tokens: p.tokens, scope: p.scope, part: p.info}
+}
+
+// PackSplit writes a packed representation of the file set into consecutive parts, opened with create
+// and numbered from 1. Each part is a complete archive with its own header and footer, recording which
+// part it is, and is at most limit bytes in size, measured before compression. Files are never split
+// across parts: a file that exceeds the limit on its own is written to a part of its own, which then
+// exceeds the limit as well. It returns the number of parts written.
+func (a *Aggregator) PackSplit(
+	set files.Files,
+	limit int64,
+	create func(part int) (io.WriteCloser, error),
+) (int, error) {
+	codec := a.codec(a.Format)
+	separator := []byte(separatorOf(codec))
+	id := partID(set)
+
+	var (
+		current *part
+		count   int
+	)
+
+	empty := footerSize(codec, &part{tokens: map[string]int{}, scope: a.Scope})
+
+	err := a.packFiles(codec, set, func(result packed) error {
+		growth := footerGrowth(codec, empty, a.Scope, result)
+
+		if current != nil {
+			size := current.size + int64(len(separator)+len(result.block))
+
+			// Rendering the footer takes time proportional to the number of files in the part,
+			// so it is only done when the bound does not prove that the file fits.
+			if size+current.footer+growth > limit {
+				exact := footerSize(codec, current, result)
+				if size+exact > limit {
+					err := current.finish(codec)
+					current = nil
+
+					if err != nil {
+						return err
+					}
+				} else {
+					current.footer = exact - growth
+				}
+			}
+		}
+
+		if current == nil {
+			count++
+
+			writer, err := create(count)
+			if err != nil {
+				return err
+			}
+
+			current = &part{
+				writer:  writer,
+				entries: newManifest(),
+				tokens:  map[string]int{},
+				scope:   a.Scope,
+				info:    &Part{Index: count, Files: len(set), ID: id},
+			}
+			current.footer = footerSize(codec, current)
+
+			if err := current.write([]byte(codec.header())); err != nil {
+				return err
+			}
+		} else if err := current.write(separator); err != nil {
+			return err
+		}
+
+		if err := current.write(result.block); err != nil {
+			return err
+		}
+
+		current.set.AddFile(result.file)
+		current.entries.add(result.file.Path(), result.sum)
+		current.tokens[result.file.Path()] = result.tokens
+		current.footer += growth
+
+		if len(current.set) == 1 && current.size+footerSize(codec, current) > limit {
+			a.Logger.Warnf("%q exceeds the split size on its own, writing it to part %d anyway", result.file, count)
+		}
+
+		return nil
+	})
+
+	if current != nil {
+		if err != nil {
+			current.writer.Close()
+
+			return count, err
+		}
+
+		err = current.finish(codec)
+	}
+
+	return count, err
+}
+
+// footerSize returns the size of the footer codec writes for the part extended by extra.
+// The digest is not known in advance, but always has the same length.
+func footerSize(codec codec, current *part, extra ...packed) int64 {
+	summary := current.report(checksum(nil))
+	summary.set, summary.tokens = slices.Clone(current.set), maps.Clone(current.tokens)
+
+	for _, result := range extra {
+		summary.set.AddFile(result.file)
+		summary.tokens[result.file.Path()] = result.tokens
+	}
+
+	return int64(len(codec.footer(summary)))
+}
+
+// footerSlack bounds how much adding a file can lengthen the counts and separators in a footer:
+// the digits of the file count and the estimated number of tokens, and a separator between tree nodes.
+const footerSlack = 4
+
+// footerGrowth returns an upper bound of how much adding result to a part grows its footer, given the
+// size of the footer of an empty part without part record. Alone, the file adds its size to the footer
+// of an empty part. Added to a part, its tree lines may be indented by wider prefixes, the lines of the
+// nodes it is inserted after may become wider as well, once per line and level, and the counts may
+// become longer.
+func footerGrowth(codec codec, empty int64, scope *Scope, result packed) int64 {
+	alone := footerSize(codec, &part{tokens: map[string]int{}, scope: scope}, result) - empty
+	depth := int64(strings.Count(result.file.Path(), "/"))
+
+	return alone + 4*depth*(depth+1) + footerSlack //nolint:mnd	// Up to two bytes wider per level, twice.
+}
+
+// checkParts checks the parts of split archives among the archives, given the outcome of unpacking them all:
+// they must belong to the same archive, each part must be passed once, and together they must not hold more
+// entries than the archive, as parts left over from an earlier split would add. It returns the number of
+// files of the archive that the parts lack, as when only some of the parts are passed.
+func checkParts(result Unpacked, archives int) (int, error) {
+	if len(result.Parts) == 0 {
+		return 0, nil
+	}
+
+	if len(result.Parts) != archives {
+		return 0, errors.New("cannot combine the parts of a split archive with other archives")
+	}
+
+	first := result.Parts[0]
+	seen := make(map[int]bool, len(result.Parts))
+
+	for _, part := range result.Parts {
+		switch {
+		case part.ID != first.ID || part.Files != first.Files:
+			return 0, errors.New("the parts belong to different split archives: " + leftoverParts)
+		case seen[part.Index]:
+			return 0, fmt.Errorf("part %d is passed more than once", part.Index)
+		}
+
+		seen[part.Index] = true
+	}
+
+	if entries := len(result.Entries); entries > first.Files {
+		return 0, fmt.Errorf(
+			"the parts hold %d entries, but the archive only %d: %s",
+			entries,
+			first.Files,
+			leftoverParts,
+		)
+	}
+
+	return first.Files - len(result.Entries), nil
+}
+
+// leftoverParts explains how to resolve parts of different splits passed together.
+const leftoverParts = "parts left over from an earlier split may have been passed, pack the archive again"
+
+// removeStaleParts removes the parts of an earlier split of output numbered after the last one written,
+// so that they are not mistaken for parts of the new archive. It returns the number of parts removed.
+func removeStaleParts(output string, parts int) (int, error) {
+	removed := 0
+
+	for number := parts + 1; ; number++ {
+		name := partName(output, number)
+
+		if _, err := os.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+			return removed, nil
+		}
+
+		if err := os.Remove(name); err != nil {
+			return removed, fmt.Errorf("remove %s: %w", name, err)
+		}
+
+		removed++
+	}
+}
+
+// partName returns the name of the given part of the split archive output,
+// inserting the zero-padded part number before the extension, as in "pack.001.aggr" or "pack.001.aggr.gz".
+func partName(output string, part int) string {
+	return partNameWith(output, fmt.Sprintf("%03d", part))
+}
+
+// partPattern returns an ignore pattern matching all parts of the split archive output.
+func partPattern(output string) string {
+	return partNameWith(output, "[0-9]*")
+}
+
+// partNameWith inserts number before the extension of output, keeping a compressed extension last.
+func partNameWith(output, number string) string {
+	base := output
+	suffix := ""
+
+	if IsCompressed(output) {
+		split := len(output) - len(compressedExtension)
+		base, suffix = output[:split], output[split:]
+	}
+
+	ext := filepath.Ext(base)
+
+	return strings.TrimSuffix(base, ext) + "." + number + ext + suffix
+}
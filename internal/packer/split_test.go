@@ -0,0 +1,117 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// nopCloser adds a Close method that does nothing to a buffer.
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+// Close does nothing.
+func (nopCloser) Close() error { return nil }
+
+// packSplit packs contents into parts of at most limit bytes and returns them.
+func packSplit(t *testing.T, contents map[string]string, limit int64) []string {
+	t.Helper()
+
+	a := newTestAggregator(t)
+	packContents(t, a, contents)
+
+	collected, err := walk(a.Logger, a.Root, []string{"**"}, nil, len(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffers []*bytes.Buffer
+
+	if _, err := a.PackSplit(collected, limit, func(int) (io.WriteCloser, error) {
+		buffers = append(buffers, &bytes.Buffer{})
+
+		return nopCloser{buffers[len(buffers)-1]}, nil
+	}); err != nil {
+		t.Fatalf("PackSplit() error = %v", err)
+	}
+
+	parts := make([]string, 0, len(buffers))
+	for _, buffer := range buffers {
+		parts = append(parts, buffer.String())
+	}
+
+	return parts
+}
+
+func TestCheckParts(t *testing.T) {
+	t.Parallel()
+
+	contents := map[string]string{}
+	for i := range 20 {
+		contents[fmt.Sprintf("file%02d.txt", i)] = strings.Repeat("content\n", 20)
+	}
+
+	parts := packSplit(t, contents, 1000)
+	if len(parts) < 3 {
+		t.Fatalf("packed into %d parts, want at least 3", len(parts))
+	}
+
+	// Packing the same files into smaller parts leaves more parts, with the same ID.
+	smaller := packSplit(t, contents, 600)
+	if len(smaller) <= len(parts) {
+		t.Fatalf("packed into %d smaller parts, want more than %d", len(smaller), len(parts))
+	}
+
+	delete(contents, "file00.txt")
+	other := packSplit(t, contents, 1000)
+
+	tests := []struct {
+		name    string
+		parts   []string
+		missing int
+		err     string
+	}{
+		{name: "all parts", parts: parts},
+		{name: "some parts", parts: parts[1:], missing: strings.Count(parts[0], "BEGIN")},
+		{name: "part passed twice", parts: append(parts[:1:1], parts[0]), err: "part 1 is passed more than once"},
+		{name: "parts of different archives", parts: append(parts[:1:1], other[1:]...), err: "different split"},
+		{name: "part left over", parts: append(slices.Clone(parts), smaller[len(parts)]), err: "hold"},
+		{name: "not a part", parts: append(parts[:1:1], packContents(t, newTestAggregator(t), contents)), err: "other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var result Unpacked
+
+			a := newTestAggregator(t)
+			a.Dry = true
+
+			for _, part := range test.parts {
+				unpacked, err := a.Unpack(context.Background(), strings.NewReader(part), t.TempDir(), nil)
+				if err != nil {
+					t.Fatalf("Unpack() error = %v", err)
+				}
+
+				result.Merge(unpacked)
+			}
+
+			missing, err := checkParts(result, len(test.parts))
+
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("checkParts() error = %v", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("checkParts() error = %v, want %q", err, test.err)
+			case missing != test.missing:
+				t.Errorf("checkParts() = %d missing, want %d", missing, test.missing)
+			}
+		})
+	}
+}
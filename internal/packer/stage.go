@@ -0,0 +1,232 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/idelchi/aggr/internal/checkers"
+	"github.com/idelchi/aggr/internal/patterns"
+)
+
+// stagePrefix starts the name of the temporary directories entries are staged in.
+// Entries with such a name are refused, and walking skips directories with such a name.
+const stagePrefix = ".aggr-unpack-"
+
+// staged excludes the staging directories, of this or of an interrupted run, from walking.
+var staged = checkers.NewIgnore(patterns.Patterns{stagePrefix + "*/"}.AsGitIgnore())
+
+// Stage creates a temporary directory on the same filesystem as dst and directs all following calls of
+// Unpack to write into it instead of into dst. Existing entries in dst are still checked and resolved as
+// if they were written directly. Commit moves the staged entries into dst, Discard removes them.
+//
+// If dst exists, the staging directory is created inside it, where writing is allowed and which is on the
+// same filesystem even if dst is a mount point. Walking skips it, so it is neither packed nor pruned.
+// Otherwise, it is created next to dst, to be renamed to dst.
+func (a *Aggregator) Stage(dst string) error {
+	absolute, err := filepath.Abs(dst)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", dst, err)
+	}
+
+	// The parent of a relative dst such as "." is dst itself, so it is taken from the absolute path.
+	parent := filepath.Dir(absolute)
+
+	info, err := os.Stat(absolute)
+
+	switch {
+	case err == nil && !info.IsDir():
+		return fmt.Errorf("%s is not a directory", dst)
+	case err == nil:
+		parent = absolute
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	default:
+		if err := os.MkdirAll(parent, dirPerm); err != nil {
+			return fmt.Errorf("create %s: %w", parent, err)
+		}
+	}
+
+	dir, err := os.MkdirTemp(parent, stagePrefix+"*")
+	if err != nil {
+		return fmt.Errorf("create staging directory: %w", err)
+	}
+
+	// The staging directory becomes dst if dst does not exist yet.
+	if err := os.Chmod(dir, dirPerm); err != nil {
+		os.RemoveAll(dir)
+
+		return fmt.Errorf("chmod %s: %w", dir, err)
+	}
+
+	a.Staging = dir
+
+	return nil
+}
+
+// Commit moves the staged entries into dst and removes the staging directory.
+// If dst does not exist, the staging directory is renamed to dst in a single step. Otherwise, every staged
+// entry replaces the entry at the same path in dst, and new directories are moved as a whole. Each entry is
+// moved by renaming it, so no file in dst is ever partially written.
+//
+// Replaced entries are moved into the staging directory, or renamed as backups under PolicyBackup. If moving
+// any entry fails, all moves are undone, leaving dst as it was. Should undoing fail as well, the staging
+// directory is kept, with the replaced entries that could not be put back.
+func (a *Aggregator) Commit(dst string) error {
+	defer a.Discard() //nolint:errcheck	// Nothing is left to keep once the entries are moved or put back.
+
+	if _, err := os.Lstat(dst); errors.Is(err, fs.ErrNotExist) {
+		if err := os.Rename(a.Staging, dst); err != nil {
+			return fmt.Errorf("move %s to %s: %w", a.Staging, dst, err)
+		}
+
+		a.Staging = ""
+
+		return nil
+	}
+
+	var (
+		moved   journal
+		backups []string
+	)
+
+	if err := a.commit(dst, &moved, &backups); err != nil {
+		if undoErr := moved.undo(); undoErr != nil {
+			staging := a.Staging
+			a.Staging = ""
+
+			return fmt.Errorf(
+				"%w: restoring %s failed, replaced entries are kept in %s: %w",
+				err,
+				dst,
+				staging,
+				undoErr,
+			)
+		}
+
+		return fmt.Errorf("%w: %s was left as it was", err, dst)
+	}
+
+	for _, path := range backups {
+		a.Logger.Infof("Backed up %q to %q", path, moved.destination(path))
+	}
+
+	return nil
+}
+
+// commit moves the staged entries into the existing dst, see Commit, recording every move in moved
+// and the backed up paths in backups.
+func (a *Aggregator) commit(dst string, moved *journal, backups *[]string) error {
+	// The replaced entries are kept below the staging directory, on the same filesystem, until the end.
+	replaced := filepath.Join(a.Staging, stagePrefix+"replaced")
+
+	return filepath.WalkDir(a.Staging, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == replaced {
+			return fs.SkipDir
+		}
+
+		rel, err := filepath.Rel(a.Staging, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		info, err := os.Lstat(target)
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return err
+		case info.IsDir() && entry.IsDir():
+			// Staged directories are merged into existing ones.
+			return nil
+		case info.IsDir():
+			// Refused when unpacking, unless the directory was created since.
+			return fmt.Errorf("cannot replace directory %s", target)
+		case a.Overwrite == PolicyBackup:
+			if err := moved.move(target, backupPath(target)); err != nil {
+				return err
+			}
+
+			*backups = append(*backups, target)
+		default:
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(replaced, rel)), dirPerm); err != nil {
+				return fmt.Errorf("create %s: %w", replaced, err)
+			}
+
+			if err := moved.move(target, filepath.Join(replaced, rel)); err != nil {
+				return err
+			}
+		}
+
+		if err := moved.move(path, target); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return fs.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// journal records the renames done by Commit, in order, to undo them if committing fails.
+type journal []struct {
+	from, to string
+}
+
+// move renames from to to and records it.
+func (j *journal) move(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("move %s to %s: %w", from, to, err)
+	}
+
+	*j = append(*j, struct{ from, to string }{from, to})
+
+	return nil
+}
+
+// destination returns the path the entry at from was moved to, or an empty string if it was not moved.
+func (j journal) destination(from string) string {
+	for _, move := range j {
+		if move.from == from {
+			return move.to
+		}
+	}
+
+	return ""
+}
+
+// undo renames the recorded entries back, the last one first, and reports the entries it failed to restore.
+func (j journal) undo() error {
+	var errs []error
+
+	for _, move := range slices.Backward(j) {
+		if err := os.Rename(move.to, move.from); err != nil {
+			errs = append(errs, fmt.Errorf("restore %s: %w", move.from, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Discard removes the staging directory and everything staged in it, if any.
+func (a *Aggregator) Discard() error {
+	if a.Staging == "" {
+		return nil
+	}
+
+	err := os.RemoveAll(a.Staging)
+	a.Staging = ""
+
+	return err
+}
@@ -0,0 +1,181 @@
+package packer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// names returns the sorted names of the entries in dir.
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestStage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		exists bool
+	}{
+		{name: "missing destination"},
+		{name: "existing destination", exists: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "dst")
+			a := newTestAggregator(t)
+			a.Overwrite = PolicyForce
+
+			if test.exists {
+				if err := os.MkdirAll(filepath.Join(dst, "kept"), dirPerm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := a.Stage(dst); err != nil {
+				t.Fatal(err)
+			}
+
+			// Staging inside an existing destination avoids needing write access to, or a rename across, its parent.
+			want := parent
+			if test.exists {
+				want = dst
+			}
+
+			if got := filepath.Dir(a.Staging); got != want {
+				t.Errorf("staged in %q, want %q", got, want)
+			}
+
+			result := unpack(t, a, dst, fileEntry("a/b.txt", "b")+fileEntry(stagePrefix+"x/c.txt", "c"))
+
+			if got := rejected(result); !slices.Equal(got, []string{stagePrefix + "x/c.txt"}) {
+				t.Errorf("rejected %v, want the entry with a reserved name", got)
+			}
+
+			if test.exists {
+				collected, err := walk(a.Logger, dst, []string{"**"}, nil, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(collected) != 1 || collected[0].Path() != "kept" {
+					t.Errorf("walked %v, want only the existing entries", collected)
+				}
+			}
+
+			if err := a.Commit(dst); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+
+			if got := names(t, parent); !slices.Equal(got, []string{"dst"}) {
+				t.Errorf("entries next to the destination: %v, want only dst", got)
+			}
+
+			wantNames := []string{"a"}
+			if test.exists {
+				wantNames = []string{"a", "kept"}
+			}
+
+			if got := names(t, dst); !slices.Equal(got, wantNames) {
+				t.Errorf("entries in the destination: %v, want %v", got, wantNames)
+			}
+
+			if data, err := os.ReadFile(filepath.Join(dst, "a", "b.txt")); err != nil || string(data) != "b\n" {
+				t.Errorf("a/b.txt = %q, %v, want %q", data, err, "b\n")
+			}
+		})
+	}
+}
+
+func TestStageTypeConflicts(t *testing.T) {
+	t.Parallel()
+
+	dst := t.TempDir()
+	a := newTestAggregator(t)
+	a.Overwrite = PolicyForce
+
+	if err := os.MkdirAll(filepath.Join(dst, "dir", "sub"), dirPerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dst, "file"), []byte("file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Stage(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	result := unpack(t, a, dst, fileEntry("dir", "dir")+fileEntry("file/x", "x")+linkEntry("dir/sub", "."))
+
+	if got, want := rejected(result), []string{"dir", "dir/sub", "file/x"}; !slices.Equal(got, want) {
+		t.Errorf("rejected %v, want %v", got, want)
+	}
+
+	if err := a.Commit(dst); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	if got := names(t, dst); !slices.Equal(got, []string{"dir", "file"}) {
+		t.Errorf("entries in the destination: %v, want them untouched", got)
+	}
+}
+
+func TestStageCommitRollback(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []Policy{PolicyForce, PolicyBackup} {
+		t.Run(string(policy), func(t *testing.T) {
+			t.Parallel()
+
+			dst := t.TempDir()
+			a := newTestAggregator(t)
+			a.Overwrite = policy
+
+			if err := os.WriteFile(filepath.Join(dst, "a.txt"), []byte("local\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.Stage(dst); err != nil {
+				t.Fatal(err)
+			}
+
+			unpack(t, a, dst, fileEntry("a.txt", "archive")+fileEntry("b.txt", "archive")+fileEntry("c/d.txt", "d"))
+
+			// A directory created after unpacking makes moving b.txt fail, after a.txt was replaced.
+			if err := os.Mkdir(filepath.Join(dst, "b.txt"), dirPerm); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.Commit(dst); err == nil {
+				t.Fatal("Commit() succeeded, want an error")
+			}
+
+			if got := names(t, dst); !slices.Equal(got, []string{"a.txt", "b.txt"}) {
+				t.Errorf("entries in the destination: %v, want them as they were", got)
+			}
+
+			if data, err := os.ReadFile(filepath.Join(dst, "a.txt")); err != nil || string(data) != "local\n" {
+				t.Errorf("a.txt = %q, %v, want the local content restored", data, err)
+			}
+		})
+	}
+}
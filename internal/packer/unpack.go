@@ -1,20 +1,37 @@
 package packer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/idelchi/aggr/internal/checkers"
 	"github.com/idelchi/aggr/internal/patterns"
+	"github.com/idelchi/godyl/pkg/logger"
 	"github.com/idelchi/godyl/pkg/path/file"
+	"github.com/idelchi/godyl/pkg/path/files"
 	"github.com/idelchi/godyl/pkg/path/folder"
 )
 
-// Unpack extracts files from an aggregated file and recreates the original directory structure.
-// It reads the packed file from the given path and writes the extracted files to the
-// configured output directory.
-func (p Packer) Unpack(packs []string) error {
-	path := packs[0] // Expecting a single file path for unpacking
+// Unpack extracts files from aggregated files and recreates the original directory structure.
+// It reads the packed files from the given paths, such as the parts of a split archive, or from
+// standard input if the only path is "-", and writes the extracted files to the configured output directory.
+//
+// Unpacking is all-or-nothing: files are staged in a temporary directory, inside the output directory if it
+// exists, and only moved into place once all archives have been parsed and all files written. If unpacking
+// fails or is interrupted, the staged files are removed and the output directory is left untouched.
+//
+// In prune mode, files in the output directory that are in the scope recorded in the archives but have no
+// entry in them are deleted, once the unpacked files have been moved into place. Pruning with only some of
+// the parts of a split archive is refused.
+func (p Packer) Unpack(ctx context.Context, packs []string) error {
+	path := packs[0] // The first archive names the default output directory
 
 	log, err := Logger(p.Options.Dry)
 	if err != nil {
@@ -23,9 +40,52 @@ func (p Packer) Unpack(packs []string) error {
 
 	// Read the packed file
 	archive := file.New(path)
+	fromStdin := slices.Contains(packs, StdinArchive)
+
+	if fromStdin && len(packs) > 1 {
+		return errors.New("cannot combine archives read from stdin with other archives")
+	}
+
+	if fromStdin && p.Options.Base == StdinArchive {
+		return errors.New("cannot read both the base archive and the archive from stdin")
+	}
+
+	if fromStdin {
+		archive = file.New("stdin")
+	}
 
 	// Create unpacker instance
-	unpacker := NewAggregator(log, p.Options.Dry, p.Options.Parallel, p.Options.Rules.Root)
+	unpacker, err := p.reader(log)
+	if err != nil {
+		return err
+	}
+
+	unpacker.Metadata = !p.Options.NoMetadata
+	unpacker.Overwrite = p.policy()
+	unpacker.Update = p.Options.Update
+
+	if unpacker.ConflictStyle, err = ParseConflictStyle(p.Options.ConflictStyle); err != nil {
+		return err
+	}
+
+	// Standard input carries the archive when reading from it, so it can only be used to prompt otherwise.
+	if !fromStdin && IsTerminal(os.Stdin) {
+		unpacker.Confirm = NewPrompt()
+	}
+
+	if p.Options.Base != "" {
+		reader, err := OpenArchive(p.Options.Base)
+		if err != nil {
+			return err
+		}
+
+		unpacker.Base, err = unpacker.ReadEntries(ctx, reader)
+		reader.Close()
+
+		if err != nil {
+			return fmt.Errorf("reading base archive %q: %w", p.Options.Base, err)
+		}
+	}
 
 	ignorePatterns := patterns.Patterns(p.Options.Rules.Patterns)
 
@@ -51,7 +111,12 @@ func (p Packer) Unpack(packs []string) error {
 
 	output := folder.New(p.Options.Output)
 
-	if p.Options.Output == "" {
+	switch {
+	case p.Options.Output != "":
+	case fromStdin:
+		// A stream cannot be hashed before it is read, so the time of unpacking is used instead.
+		output = folder.New(fmt.Sprintf("%s-%s", archive.Base(), time.Now().Format("20060102-150405")))
+	default:
 		hash, err := archive.Hash()
 		if err != nil {
 			return fmt.Errorf("calculating archive hash: %w", err)
@@ -60,18 +125,72 @@ func (p Packer) Unpack(packs []string) error {
 		output = folder.New(fmt.Sprintf("%s-%s", archive.Base(), hash))
 	}
 
-	// if output exists as a directory, prompt the user
-	if !PromptForFolderExists(output) {
-		return errors.New("aborted unpacking")
+	// Interrupting stops unpacking and removes the staged files. Interrupting again exits immediately.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	context.AfterFunc(ctx, stop)
+
+	if !p.Options.Dry {
+		if err := unpacker.Stage(output.Path()); err != nil {
+			return err
+		}
+
+		defer unpacker.Discard() //nolint:errcheck	// Best effort, nothing is left to stage after a commit.
 	}
 
-	// Unpack the files
-	files, err := unpacker.Unpack(archive, output.Path(), checkers)
+	// Unpack the files, one archive after another
+	var result Unpacked
+
+	for _, path := range packs {
+		reader, err := OpenArchive(path)
+		if err != nil {
+			return err
+		}
+
+		unpacked, err := unpacker.Unpack(ctx, reader, output.Path(), checkers)
+		reader.Close()
+
+		result.Merge(unpacked)
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("unpacking interrupted: nothing was written to %q", output)
+		}
+
+		if err != nil {
+			return fmt.Errorf("unpacking files from %q: nothing was written to %q: %w", path, output, err)
+		}
+	}
+
+	missing, err := checkParts(result, len(packs))
 	if err != nil {
-		return fmt.Errorf("unpacking files: %w", err)
+		return fmt.Errorf("unpacking split archive: nothing was written to %q: %w", output, err)
+	}
+
+	// The files of the parts that are not passed would be deleted as if they were removed from the archive.
+	if missing > 0 && p.Options.Prune {
+		return fmt.Errorf(
+			"cannot prune %q: the parts passed lack %d files of the split archive, pass all its parts",
+			output,
+			missing,
+		)
+	}
+
+	if missing > 0 {
+		log.Warnf("The parts passed lack %d files of the split archive: pass all its parts to unpack them", missing)
+	}
+
+	for _, path := range duplicates(result.Entries) {
+		log.Warnf("%q has more than one entry in the archives, only one of them is kept", path)
+	}
+
+	if len(result.Rejected) > 0 {
+		log.Warnf("Refused %d entries with unsafe paths, see the warnings above", len(result.Rejected))
 	}
 
-	if len(files) == 0 {
+	written := result.Written()
+
+	if len(written) == 0 && len(result.Unchanged) == 0 {
 		log.Warn("No files found matching the specified patterns and rules")
 
 		return nil
@@ -79,15 +198,139 @@ func (p Packer) Unpack(packs []string) error {
 
 	if p.Options.Dry {
 		log.Info("Unpacking files:")
+	}
+
+	p.report(log, result)
+
+	var stale files.Files
+
+	if p.Options.Prune {
+		// The archives themselves are never pruned, even if they are in scope.
+		keep := append(slices.Clone(packs), p.Options.Base)
 
-		for _, f := range files {
-			log.Debugf("- %q", f)
+		if stale, err = p.stale(log, result, output, keep, checkers); err != nil {
+			return err
 		}
 
+		for _, f := range stale {
+			log.Infof("- deleted: %q", file.New(output.Path(), f.Path()))
+		}
+	}
+
+	if p.Options.Dry {
 		return nil
 	}
 
-	log.Infof("Successfully unpacked %d files from %q to %q", len(files), archive, output)
+	if len(written) == 0 && len(stale) == 0 {
+		log.Infof("Nothing to update, %q is up to date", output)
+
+		return nil
+	}
+
+	if len(written) > 0 {
+		if err := unpacker.Commit(output.Path()); err != nil {
+			return fmt.Errorf("moving unpacked files to %q: %w", output, err)
+		}
+
+		source := fmt.Sprintf("%q", archive)
+		if len(packs) > 1 {
+			source = fmt.Sprintf("%d archives", len(packs))
+		}
+
+		log.Infof("Successfully unpacked %d files from %s to %q", len(written), source, output)
+	}
+
+	if len(stale) > 0 {
+		if err := unpacker.Prune(output.Path(), stale, result.Entries); err != nil {
+			return fmt.Errorf("pruning %q: %w", output, err)
+		}
+
+		verb := "Deleted"
+		if unpacker.Overwrite == PolicyBackup {
+			verb = "Backed up"
+		}
+
+		log.Infof("%s %d files in %q that are not in the archive", verb, len(stale), output)
+	}
+
+	if len(result.Conflicted) > 0 {
+		return fmt.Errorf(
+			"%d file(s) in %q have conflicts with local changes to resolve",
+			len(result.Conflicted),
+			output,
+		)
+	}
 
 	return nil
 }
+
+// report lists the created, updated and unchanged files, followed by their counts in update mode
+// or when merging with a base archive. Created and updated files are listed in update mode, all files
+// in dry run mode. Merged files and files with conflicts are always listed.
+func (p Packer) report(log *logger.Logger, result Unpacked) {
+	list := log.Debugf
+	if p.Options.Update {
+		list = log.Infof
+	}
+
+	for _, group := range []struct {
+		kind  string
+		files files.Files
+		list  func(format string, args ...any)
+	}{
+		{"created", result.Created, list},
+		{"updated", result.Updated, list},
+		{"merged", result.Merged, log.Infof},
+		{"conflict", result.Conflicted, log.Warnf},
+		{"unchanged", result.Unchanged, log.Debugf},
+	} {
+		slices.SortFunc(group.files, func(a, b file.File) int { return strings.Compare(a.Path(), b.Path()) })
+
+		for _, f := range group.files {
+			group.list("- %s: %q", group.kind, f)
+		}
+	}
+
+	switch {
+	case p.Options.Base != "":
+		log.Infof("%d created, %d updated, %d merged, %d conflicts, %d unchanged", len(result.Created),
+			len(result.Updated), len(result.Merged), len(result.Conflicted), len(result.Unchanged))
+	case p.Options.Update:
+		log.Infof("%d created, %d updated, %d unchanged",
+			len(result.Created), len(result.Updated), len(result.Unchanged))
+	}
+}
+
+// policy returns the overwrite policy selected by the options.
+func (p Packer) policy() Policy {
+	switch {
+	case p.Options.Force:
+		return PolicyForce
+	case p.Options.SkipExisting:
+		return PolicySkip
+	case p.Options.Backup:
+		return PolicyBackup
+	case p.Options.FailIfExists:
+		return PolicyFail
+	case p.Options.Update:
+		// Updating an existing tree is the point of update mode, so changed files are not prompted for.
+		return PolicyForce
+	default:
+		return PolicyPrompt
+	}
+}
+
+// duplicates returns the paths that occur more than once in paths, sorted.
+func duplicates(paths []string) []string {
+	sorted := slices.Sorted(slices.Values(paths))
+
+	var repeated []string
+
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] == sorted[i-1] && (len(repeated) == 0 || repeated[len(repeated)-1] != sorted[i]) {
+			repeated = append(repeated, sorted[i])
+		}
+	}
+
+	return repeated
+}
@@ -0,0 +1,77 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/idelchi/godyl/pkg/logger"
+	"github.com/idelchi/godyl/pkg/path/file"
+)
+
+// Verify checks the integrity of packed files, such as the parts of a split archive, read from
+// standard input if the path is "-". It reports entries whose content no longer matches the recorded
+// checksums, a missing or mismatching footer, and structural damage such as truncation. If Compare is set,
+// each entry is additionally compared with the corresponding file under the configured root directory.
+func (p Packer) Verify(packs []string) error {
+	log, err := Logger(p.Options.Dry)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, path := range packs {
+		errs = append(errs, p.verify(log, path))
+	}
+
+	return errors.Join(errs...)
+}
+
+// verify checks the integrity of a single packed file.
+func (p Packer) verify(log *logger.Logger, path string) error {
+	archive := file.New(path)
+
+	if path == StdinArchive {
+		archive = file.New("stdin")
+	}
+
+	reader, err := OpenArchive(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	verifier, err := p.reader(log)
+	if err != nil {
+		return err
+	}
+
+	root := ""
+	if p.Options.Compare {
+		root = p.Options.Rules.Root
+
+		log.Debugf("- Comparing entries against %q", root)
+	}
+
+	problems, err := verifier.Verify(reader, root)
+
+	for _, problem := range problems {
+		if problem.Path == "" {
+			log.Warnf("- %s", problem.Reason)
+		} else {
+			log.Warnf("- %s: %s", problem.Path, problem.Reason)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("verifying %q: %w", archive, err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("verifying %q: found %d problem(s)", archive, len(problems))
+	}
+
+	log.Infof("Successfully verified %q", archive)
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/idelchi/aggr/internal/tree"
+)
+
+// XML element names, following the document layout recommended for prompts:
+//
+//	<documents format="1">
+//	<document mode="0644" sha256="...">
+//	<source>src/main.go</source>
+//	<document_content><![CDATA[
+//	package main
+//	]]></document_content>
+//	</document>
+//	</documents>
+const (
+	xmlDocuments = "documents"
+	xmlDocument  = "document"
+	xmlSource    = "source"
+	xmlContent   = "document_content"
+	xmlTree      = "tree"
+	xmlSummary   = "summary"
+)
+
+// xmlCodec implements FormatXML.
+//
+// Entry attributes are stored as attributes of the document element and content as CDATA, with a
+// newline after the opening CDATA marker that is removed again when parsing. XML parsers normalize
+// line endings, so CRLF files are stored with LF line endings, as in the other formats, and text that
+// XML cannot represent exactly, such as invalid UTF-8 or control characters, is stored as base64.
+type xmlCodec struct {
+	a *Aggregator
+}
+
+// xmlDocumentElement is a document element as decoded from an archive.
+type xmlDocumentElement struct {
+	Attrs   []xml.Attr         `xml:",any,attr"`
+	Source  string             `xml:"source"`
+	Content *xmlContentElement `xml:"document_content"`
+}
+
+// xmlContentElement is the content element of a document.
+type xmlContentElement struct {
+	Text string `xml:",chardata"`
+}
+
+// header returns the opening documents element naming the format version.
+func (c xmlCodec) header() string {
+	return "<" + xmlDocuments + xmlAttributes(Attributes{"format": strconv.Itoa(FormatVersion)}) + ">\n"
+}
+
+// entry renders e as a document element.
+func (c xmlCodec) entry(e entry) []byte {
+	body := e.data
+
+	if isText(e.attrs) {
+		if body = normalizeEOL(e.data, e.attrs); !isXMLText(body) {
+			delete(e.attrs, "eol")
+
+			e.attrs["encoding"] = encodingBase64
+			body = encodeBase64(e.data)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "<%s%s>\n", xmlDocument, xmlAttributes(e.attrs))
+	fmt.Fprintf(&buf, "<%s>%s</%s>\n", xmlSource, xmlEscape(formatPath(e.path)), xmlSource)
+
+	if e.attrs["type"] == "" {
+		fmt.Fprintf(&buf, "<%s>%s</%s>\n", xmlContent, cdata(body), xmlContent)
+	}
+
+	fmt.Fprintf(&buf, "</%s>\n", xmlDocument)
+
+	return buf.Bytes()
+}
+
+// footer returns the tree and the summary element holding the file count, the estimated number of tokens
+// and, if set, the scope, the part and the archive digest. Outside of dry run mode, it closes the documents element.
+func (c xmlCodec) footer(r report) string {
+	attrs := Attributes{"files": strconv.Itoa(len(r.set)), "tokens": strconv.Itoa(r.total())}
+
+	if r.scope != nil {
+		attrs["scope"] = r.scope.String()
+	}
+
+	if r.part != nil {
+		attrs["part"] = r.part.String()
+	}
+
+	if r.digest != "" {
+		attrs["sha256"] = r.digest
+	}
+
+	footer := fmt.Sprintf("<%s>%s</%s>\n<%s%s/>\n",
+		xmlTree, cdata([]byte(tree.Generate(r.set, c.a.label(r)).String())), xmlTree, xmlSummary, xmlAttributes(attrs))
+
+	if !c.a.Dry {
+		footer += "</" + xmlDocuments + ">\n"
+	}
+
+	return footer
+}
+
+// parse reads an archive in FormatXML.
+// Document elements are picked up wherever they appear, so responses that wrap them in other text,
+// or omit the documents element, can be parsed as well. Text that is not valid XML outside of the
+// document elements, such as a bare "<" or "&" in prose, is skipped.
+func (c xmlCodec) parse(ctx context.Context, reader *bufio.Reader, chunks chan<- fileChunk) (footer, error) {
+	var summary footer
+
+	decoder := newXMLDecoder(reader)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return summary, ctx.Err()
+		default:
+		}
+
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+
+		if syntaxErr := (*xml.SyntaxError)(nil); errors.As(err, &syntaxErr) {
+			// The decoder reads byte by byte, so it stopped right at the error. Parsing restarts at
+			// the next element of the archive.
+			if !skipToElement(reader) {
+				return summary, nil
+			}
+
+			decoder = newXMLDecoder(reader)
+
+			continue
+		}
+
+		if err != nil {
+			return summary, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		attrs := Attributes{}
+		for _, attr := range start.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+
+		switch start.Name.Local {
+		case xmlDocuments:
+			if format, ok := attrs["format"]; ok {
+				if err := checkVersion(attrs, fmt.Sprintf("<%s format=%q>", xmlDocuments, format)); err != nil {
+					return summary, err
+				}
+			}
+
+		case xmlDocument:
+			chunk, err := c.decodeDocument(decoder, start)
+			if err != nil {
+				return summary, err
+			}
+
+			chunks <- chunk
+
+		case xmlSummary:
+			summary.found = true
+			summary.digest = attrs["sha256"]
+
+			if text, ok := attrs["scope"]; ok {
+				if summary.scope, err = parseScope(text); err != nil {
+					return summary, fmt.Errorf("invalid scope: %w", err)
+				}
+			}
+
+			if text, ok := attrs["part"]; ok {
+				if summary.part, err = parsePart(text); err != nil {
+					return summary, fmt.Errorf("invalid part: %w", err)
+				}
+			}
+
+			if summary.files, err = attrs.Int("files", 0); err != nil {
+				return summary, err
+			}
+		}
+	}
+}
+
+// newXMLDecoder returns a decoder reading from reader that tolerates prose around the document elements:
+// unknown entities, unclosed and mismatched elements, and HTML entities and void elements.
+func newXMLDecoder(reader *bufio.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(reader)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	return decoder
+}
+
+// skipToElement discards input up to the next documents, document or summary element.
+// It reports whether such an element was found.
+func skipToElement(reader *bufio.Reader) bool {
+	for {
+		start, _ := reader.Peek(len("<" + xmlDocuments + ">"))
+		if len(start) == 0 {
+			return false
+		}
+
+		for _, name := range []string{xmlDocuments, xmlDocument, xmlSummary} {
+			rest, ok := bytes.CutPrefix(start, []byte("<"+name))
+			if ok && (len(rest) == 0 || bytes.ContainsAny(rest[:1], " \t\r\n/>")) {
+				return true
+			}
+		}
+
+		if _, err := reader.Discard(1); err != nil {
+			return false
+		}
+	}
+}
+
+// decodeDocument decodes the document element opened by start into a file chunk.
+func (c xmlCodec) decodeDocument(decoder *xml.Decoder, start xml.StartElement) (fileChunk, error) {
+	var document xmlDocumentElement
+
+	if err := decoder.DecodeElement(&document, &start); err != nil {
+		return fileChunk{}, err
+	}
+
+	path, err := parsePath(strings.TrimSpace(document.Source))
+	if err != nil {
+		return fileChunk{}, err
+	}
+
+	attrs := Attributes{}
+	for _, attr := range document.Attrs {
+		attrs[attr.Name.Local] = attr.Value
+	}
+
+	chunk := fileChunk{path: path, attrs: attrs}
+
+	if attrs["type"] != "" {
+		return chunk, nil
+	}
+
+	if document.Content == nil {
+		return fileChunk{}, fmt.Errorf("%s: missing %s", path, xmlContent)
+	}
+
+	chunk.data = []byte(strings.TrimPrefix(document.Content.Text, "\n"))
+
+	if isText(attrs) {
+		if chunk.data, err = restoreEOL(chunk.data, attrs); err != nil {
+			return fileChunk{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return chunk, nil
+}
+
+// xmlAttributes renders attrs as XML attributes with keys in sorted order, each preceded by a space.
+func xmlAttributes(attrs Attributes) string {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	var builder strings.Builder
+
+	for _, key := range keys {
+		fmt.Fprintf(&builder, ` %s="%s"`, key, xmlEscape(attrs[key]))
+	}
+
+	return builder.String()
+}
+
+// xmlEscape escapes text for use in XML character data and attribute values.
+func xmlEscape(text string) string {
+	var builder strings.Builder
+
+	_ = xml.EscapeText(&builder, []byte(text))
+
+	return builder.String()
+}
+
+// cdata wraps data in a CDATA section, preceded by a newline.
+// Occurrences of the CDATA terminator in data are split across two sections.
+func cdata(data []byte) string {
+	return "<![CDATA[\n" + strings.ReplaceAll(string(data), "]]>", "]]]]><![CDATA[>") + "]]>"
+}
+
+// isXMLText reports whether data is valid UTF-8 that XML represents exactly:
+// it contains no carriage returns, which parsers normalize, and no characters XML does not allow.
+func isXMLText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+
+	for _, r := range string(data) {
+		switch {
+		case r == '\t', r == '\n':
+		case r < ' ', r == '\uFFFE', r == '\uFFFF':
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,42 @@
+package packer
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestXMLParseProse(t *testing.T) {
+	t.Parallel()
+
+	const stream = `Sure! Since a < b && b > c, I changed both files. See <https://example.com> & <br> for details:
+<p>
+<document mode="0644">
+<source>main.go</source>
+<document_content><![CDATA[
+if a < b && c {
+}
+]]></document_content>
+</document>
+
+Tom & Jerry say: x<y, and <3 to everyone.
+
+<document>
+<source>notes.txt</source>
+<document_content>1 &lt; 2 &amp; 3</document_content>
+</document>
+Let me know if you need anything else &
+`
+
+	aggregator := newTestAggregator(t)
+	aggregator.Format = FormatXML
+
+	got := readEntries(t, aggregator, stream)
+	want := map[string]string{
+		"main.go":   "if a < b && c {\n}\n",
+		"notes.txt": "1 < 2 & 3",
+	}
+
+	if !maps.Equal(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+}
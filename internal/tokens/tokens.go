@@ -0,0 +1,46 @@
+// Package tokens estimates the number of tokens a language model needs for a text.
+//
+// Exact counts depend on the tokenizer of each model. The Tokenizer interface allows plugging in
+// an exact implementation, while Approximate provides a reasonable estimate without any vocabulary.
+package tokens
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens in a text.
+type Tokenizer interface {
+	// Count returns the number of tokens in data.
+	Count(data []byte) int
+}
+
+// charsPerToken is the average number of characters per token within words.
+const charsPerToken = 4
+
+// Approximate estimates tokens with rules of thumb that hold for common BPE tokenizers:
+// runs of ASCII letters and digits take one token per four characters, every other character
+// that is not whitespace takes a token of its own, and so does every line break.
+type Approximate struct{}
+
+// Count returns the estimated number of tokens in data.
+func (Approximate) Count(data []byte) int {
+	count, run := 0, 0
+
+	for _, r := range string(data) {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			run++
+
+			continue
+		}
+
+		count += (run + charsPerToken - 1) / charsPerToken
+		run = 0
+
+		if r == '\n' || !unicode.IsSpace(r) {
+			count++
+		}
+	}
+
+	return count + (run+charsPerToken-1)/charsPerToken
+}
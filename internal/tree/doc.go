@@ -3,6 +3,7 @@
 // This package handles:
 //   - Converting file path lists to tree structures
 //   - Generating ASCII tree visualizations
+//   - Building structured trees for machine-readable output
 //   - Organizing files and directories hierarchically
 //   - Sorting and normalizing paths for consistent output
 //
@@ -0,0 +1,57 @@
+package tree
+
+import (
+	"strings"
+
+	"github.com/idelchi/godyl/pkg/path/file"
+	"github.com/idelchi/godyl/pkg/path/files"
+)
+
+// Node is a file or directory in a structured tree.
+type Node struct {
+	// Name is the last element of the path.
+	Name string `json:"name"`
+	// Lines is the number of lines of a file, if requested.
+	Lines int `json:"lines,omitempty"`
+	// Tokens is the estimated number of tokens of a file, if requested.
+	Tokens int `json:"tokens,omitempty"`
+	// Children holds the entries below a directory.
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Nodes creates a structured tree from a list of file paths.
+// It is the structured counterpart of Generate, for output formats that are not meant to be read as text.
+// If fill is not nil, it is called for every file node to add per-file figures.
+func Nodes(fileList files.Files, fill func(file.File, *Node)) []*Node {
+	root := &Node{}
+	branches := map[string]*Node{
+		"": root, // key = joined path parts without leading slash
+	}
+
+	for _, path := range fileList {
+		parts := strings.Split(path.Path(), "/")
+		current := root
+
+		for i := range parts {
+			key := strings.Join(parts[:i+1], "/")
+
+			if existing, exists := branches[key]; exists {
+				current = existing
+
+				continue
+			}
+
+			node := &Node{Name: parts[i]}
+
+			if i == len(parts)-1 && fill != nil {
+				fill(path, node)
+			}
+
+			current.Children = append(current.Children, node)
+			branches[key] = node
+			current = node
+		}
+	}
+
+	return root.Children
+}
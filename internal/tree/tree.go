@@ -13,9 +13,10 @@ import (
 // Generate creates a visual tree structure from a list of file paths.
 // It returns a treeprint.Tree that can be rendered as ASCII art showing
 // the hierarchical organization of the provided files.
+// If label is not nil, the non-empty labels it returns are shown in parentheses after the file names.
 func Generate( //nolint:ireturn 	// Function should return interface.
 	fileList files.Files,
-	enableNumberOfLines bool,
+	label func(file.File) string,
 ) treeprint.Tree {
 	root := treeprint.New()
 	branches := map[string]treeprint.Tree{
@@ -24,14 +25,19 @@ func Generate( //nolint:ireturn 	// Function should return interface.
 
 	// Build the tree structure
 	for _, p := range fileList {
-		addPathToTree(p, root, branches, enableNumberOfLines)
+		addPathToTree(p, root, branches, label)
 	}
 
 	return root
 }
 
 // addPathToTree adds a single path to the tree structure.
-func addPathToTree(path file.File, root treeprint.Tree, branches map[string]treeprint.Tree, enableNumberOfLines bool) {
+func addPathToTree(
+	path file.File,
+	root treeprint.Tree,
+	branches map[string]treeprint.Tree,
+	label func(file.File) string,
+) {
 	parts := strings.Split(path.Path(), "/")
 
 	keyBuilder := make([]string, 0, len(parts))
@@ -54,9 +60,8 @@ func addPathToTree(path file.File, root treeprint.Tree, branches map[string]tree
 		// Create new branch or leaf node
 		if isLastPart {
 			// This is a file (leaf node)
-			if enableNumberOfLines {
-				numberOfLines, _ := path.NumberOfLines()
-				currentTree.AddNode(fmt.Sprintf("%s (#%d)", part, numberOfLines))
+			if text := labelOf(path, label); text != "" {
+				currentTree.AddNode(fmt.Sprintf("%s (%s)", part, text))
 			} else {
 				currentTree.AddNode(part)
 			}
@@ -69,3 +74,12 @@ func addPathToTree(path file.File, root treeprint.Tree, branches map[string]tree
 		}
 	}
 }
+
+// labelOf returns the label of path, or an empty string if label is nil.
+func labelOf(path file.File, label func(file.File) string) string {
+	if label == nil {
+		return ""
+	}
+
+	return label(path)
+}
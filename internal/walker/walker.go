@@ -50,10 +50,10 @@ type Walker struct {
 	Files files.Files
 }
 
-// Walk traverses the file system using the given pattern and returns all regular files
-// that pass the configured checkers. It stops and returns an error if the maximum file limit is reached.
-//
-// TODO(Idelchi): Write tests where fsys is mocked by fstest.MapFS{}.
+// Walk traverses the file system using the given pattern and returns all regular files, symbolic links
+// and empty directories that pass the configured checkers.
+// Pass doublestar.WithNoFollow to collect symbolic links as such instead of following them.
+// It stops and returns an error if the maximum file limit is reached.
 func (w *Walker) Walk(fsys fs.FS, pattern string, opts ...doublestar.GlobOption) error {
 	base := fmt.Sprintf("%s", fsys)
 
@@ -79,16 +79,21 @@ func (w *Walker) Walk(fsys fs.FS, pattern string, opts ...doublestar.GlobOption)
 				}
 			}
 
-			if !dir.IsDir() {
-				w.Files.AddFile(fullPath)
+			if dir.IsDir() {
+				// Directories are implied by their contents, only empty ones need an entry of their own.
+				if entries, err := fs.ReadDir(fsys, p); err != nil || len(entries) > 0 {
+					return nil
+				}
+			}
 
-				w.Logger.Debugf("  - %q: included", fullPath)
+			w.Files.AddFile(fullPath)
 
-				if len(w.Files) > w.Max {
-					w.Logger.Debugf("%v: max files reached: %d", checkers.ErrAbort, w.Max)
+			w.Logger.Debugf("  - %q: included", fullPath)
 
-					return fmt.Errorf("%w: max files reached: %d: %w", checkers.ErrAbort, w.Max, fs.SkipAll)
-				}
+			if len(w.Files) > w.Max {
+				w.Logger.Debugf("%v: max files reached: %d", checkers.ErrAbort, w.Max)
+
+				return fmt.Errorf("%w: max files reached: %d: %w", checkers.ErrAbort, w.Max, fs.SkipAll)
 			}
 
 			return nil
@@ -0,0 +1,65 @@
+package walker
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// discard is a logger that drops all messages.
+type discard struct{}
+
+// Debugf drops the message.
+func (discard) Debugf(string, ...any) {}
+
+func TestWalkOddNames(t *testing.T) {
+	t.Parallel()
+
+	names := []string{
+		" leading space.txt",
+		"trailing space.txt ",
+		"ünïcødé/日本語.md",
+		"brackets [mode=0644].txt",
+		`"quoted.txt`,
+		"new\nline.txt",
+		"tab\tseparated.txt",
+		"dir with spaces/ nested .go",
+	}
+
+	fsys := fstest.MapFS{"empty": {Mode: 0o755 | fs.ModeDir}}
+	for _, name := range names {
+		fsys[name] = &fstest.MapFile{Data: []byte(name)}
+	}
+
+	w := New(nil, len(names)+1, discard{})
+
+	if err := w.Walk(fsys, "**"); err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	var got []string
+	for _, f := range w.Files {
+		got = append(got, f.Path())
+	}
+
+	want := append(slices.Clone(names), "empty")
+
+	slices.Sort(got)
+	slices.Sort(want)
+
+	if !slices.Equal(got, want) {
+		t.Errorf("Walk() collected %q, want %q", got, want)
+	}
+}
+
+func TestWalkMax(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{"a": {}, "b": {}, "c": {}}
+	w := New(nil, 2, discard{})
+
+	if err := w.Walk(fsys, "**"); err == nil {
+		t.Errorf("Walk() collected %d files past the limit of 2 without an error", len(w.Files))
+	}
+}